@@ -3,13 +3,36 @@ package align
 import (
 	"bufio"
 	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/mattn/go-runewidth"
 )
 
+// ErrAligned is returned when Align is called on an Align whose input has already been aligned.
+var ErrAligned = errors.New("align: input has already been aligned")
+
+// ErrUTF16 is returned when the input begins with a UTF-16 byte order mark.  Such input must be
+// decoded to UTF-8 before it is aligned.
+var ErrUTF16 = errors.New("align: input is UTF-16 encoded")
+
+// byte order marks recognized at the beginning of the input.
+const (
+	bomUTF8    = "\xef\xbb\xbf"
+	bomUTF16LE = "\xff\xfe"
+	bomUTF16BE = "\xfe\xff"
+)
+
 // Justification is used to set the alignment of the column
 // contents itself along the right, left, or center.
 type Justification byte
@@ -21,17 +44,516 @@ const (
 	JustifyLeft
 )
 
+// ParseJustification returns the Justification named by s, which is "left", "right" or
+// "center", or their first letter, in any case.
+func ParseJustification(s string) (Justification, error) {
+	switch strings.ToLower(s) {
+	case "left", "l":
+		return JustifyLeft, nil
+	case "right", "r":
+		return JustifyRight, nil
+	case "center", "c":
+		return JustifyCenter, nil
+	}
+	return 0, fmt.Errorf("align: invalid justification %q", s)
+}
+
+// MarshalText implements encoding.TextMarshaler, so that Justification is encoded by name,
+// including as the values of ColumnOverride, e.g. by encoding/json.  The zero value, which
+// is not set, is encoded as an empty string.
+func (j Justification) MarshalText() ([]byte, error) {
+	switch j {
+	case 0:
+		return []byte{}, nil
+	case JustifyLeft, JustifyRight, JustifyCenter:
+		return []byte(j.String()), nil
+	}
+	return nil, fmt.Errorf("align: invalid justification %d", j)
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler with ParseJustification.  An empty text
+// is the zero value.
+func (j *Justification) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*j = 0
+		return nil
+	}
+	v, err := ParseJustification(string(text))
+	if err != nil {
+		return err
+	}
+	*j = v
+	return nil
+}
+
+// String returns the name of j, as accepted by ParseJustification.
+func (j Justification) String() string {
+	switch j {
+	case JustifyLeft:
+		return "left"
+	case JustifyRight:
+		return "right"
+	case JustifyCenter:
+		return "center"
+	}
+	return "Justification(" + strconv.Itoa(int(j)) + ")"
+}
+
+// CenterBias is the side toward which centered text is placed when its padding cannot be
+// divided evenly.
+type CenterBias byte
+
+// Right or Left CenterBias options.
+const (
+	CenterBiasRight CenterBias = iota
+	CenterBiasLeft
+)
+
+// SepHug is the field that the output separator is written against, with all of the padding
+// between the two fields on the other side of it.
+type SepHug byte
+
+// None, Left or Right SepHug options.  SepHugNone surrounds the separator with padding, as in
+// "a : b"; SepHugLeft writes it after the preceding field, as in "a: b"; and SepHugRight writes
+// it before the following field, as in "a :b".
+const (
+	SepHugNone SepHug = iota
+	SepHugLeft
+	SepHugRight
+)
+
+// EmptyLinePolicy determines how lines without any text are treated.
+type EmptyLinePolicy byte
+
+// Keep, Verbatim, Skip or Error EmptyLinePolicy options.  EmptyLineKeep aligns an empty line
+// like any other, as a line with a single empty field.  EmptyLineVerbatim writes it unchanged,
+// EmptyLineSkip removes it from the output and EmptyLineError causes Align to return an error.
+const (
+	EmptyLineKeep EmptyLinePolicy = iota
+	EmptyLineVerbatim
+	EmptyLineSkip
+	EmptyLineError
+)
+
+// TruncateAnchor is the position at which a field truncated by MaxColWidth is shortened.
+type TruncateAnchor byte
+
+// End, Start or Middle TruncateAnchor options.  An ellipsis replaces the text removed
+// from the end, the start or the middle of the field, respectively.
+const (
+	TruncateEnd TruncateAnchor = iota
+	TruncateStart
+	TruncateMiddle
+)
+
+// ellipsis replaces the text removed from a truncated field.
+const ellipsis = "…"
+
 // TextQualifier is used to configure the scanner to account for a text qualifier.
+// If Open is set, a qualified field begins with Open and ends with Close, e.g. '[' and ']',
+// in place of Qualifier.  If Close is 0, Open also ends the field.
 type TextQualifier struct {
 	On        bool
 	Qualifier string
+	Open      rune
+	Close     rune
+}
+
+// textQualifierJSON is the JSON encoding of TextQualifier, with Open and Close as strings.
+type textQualifierJSON struct {
+	On        bool
+	Qualifier string
+	Open      json.RawMessage
+	Close     json.RawMessage
+}
+
+// MarshalJSON implements json.Marshaler, so that Open and Close are encoded as strings, e.g.
+// "[" rather than 91.  A rune that is not set is encoded as an empty string.
+func (q TextQualifier) MarshalJSON() ([]byte, error) {
+	runeString := func(r rune) string {
+		if r == 0 {
+			return ""
+		}
+		return string(r)
+	}
+	o, _ := json.Marshal(runeString(q.Open))
+	c, _ := json.Marshal(runeString(q.Close))
+	return json.Marshal(textQualifierJSON{On: q.On, Qualifier: q.Qualifier, Open: o, Close: c})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.  Open and Close are strings of a single character,
+// or empty if not set; numbers are also accepted, as they were encoded by earlier versions.
+func (q *TextQualifier) UnmarshalJSON(b []byte) error {
+	var v textQualifierJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	o, err := qualifierRune(v.Open)
+	if err != nil {
+		return err
+	}
+	c, err := qualifierRune(v.Close)
+	if err != nil {
+		return err
+	}
+	*q = TextQualifier{On: v.On, Qualifier: v.Qualifier, Open: o, Close: c}
+	return nil
+}
+
+// qualifierRune decodes the Open or Close rune of a TextQualifier from b, a string of at most
+// one character or a number.
+func qualifierRune(b json.RawMessage) (rune, error) {
+	if len(b) == 0 || string(b) == "null" {
+		return 0, nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		var r rune
+		if err := json.Unmarshal(b, &r); err != nil {
+			return 0, fmt.Errorf("align: invalid text qualifier rune %s", b)
+		}
+		return r, nil
+	}
+	if s == "" {
+		return 0, nil
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	if size != len(s) {
+		return 0, fmt.Errorf("align: text qualifier rune %q is not a single character", s)
+	}
+	return r, nil
+}
+
+// SkipRegion describes a region of a line, such as a string literal or comment, in which
+// separators are ignored.  The region begins with Open and ends with Close; if Close is empty
+// the region extends to the end of the line.  Close is not matched when preceded by a backslash.
+type SkipRegion struct {
+	Open  string
+	Close string
 }
 
-// PaddingOpts provides configurability for left/center/right Justification and padding length.
+// CodeRegions skips separators within double-quoted strings, raw strings and line comments,
+// which is suitable for aligning Go or C style assignments.
+var CodeRegions = []SkipRegion{
+	{Open: "//", Close: ""},
+	{Open: "\"", Close: "\""},
+	{Open: "`", Close: "`"},
+}
+
+// PaddingOpts provides configurability for left/center/right Justification and padding length,
+// along with options that affect how each line is split into fields and written.
 type PaddingOpts struct {
 	Justification  Justification
 	ColumnOverride map[int]Justification //override the Justification of specified columns
 	Pad            int                   // padding surrounding the separator
+
+	// PivotColumn right justifies the columns before the column number PivotColumn, centers it
+	// and left justifies the columns after it, as in a ledger, unless overridden by
+	// ColumnOverride.  If 0, Justification applies to every column.
+	PivotColumn int
+
+	// ColumnQualifier sets the text qualifier of the specified column numbers, overriding the
+	// TextQualifier of the Align for those columns, e.g. {2: "'"} when the second column is
+	// quoted with single quotes.
+	ColumnQualifier map[int]string
+
+	// CenterBias places centered text that cannot be centered exactly one column to the right
+	// (the default) or to the left of center, at every padding length.
+	CenterBias CenterBias
+
+	// OuterPad sets the margin before the first column and after the last column of each line,
+	// independently of the padding between columns.  If greater than 0, it replaces the padding
+	// otherwise written after the last column.
+	OuterPad int
+
+	// PadPattern fills padding with a repeating string, e.g. "-=", truncated to the width of
+	// the padding, rather than with spaces.  It overrides the padder's own padding character.
+	PadPattern string
+
+	// SepHug writes the separator against the preceding or following field of aligned lines,
+	// placing the padding of the column and the surrounding pad on the other side of it.
+	SepHug SepHug
+
+	// PadLeft and PadRight set the padding before and after the separator independently.
+	// If both are 0, Pad is used for both sides.
+	PadLeft  int
+	PadRight int
+
+	// MinGap is the least number of spaces written between the text of adjacent fields, not
+	// counting the separator, whatever Pad and the justification of the fields.  If the padding
+	// surrounding the separator is narrower, the padding before the separator is widened.
+	MinGap int
+
+	// ReAlign trims the padding surrounding each field before it is measured and written,
+	// so that aligning previously aligned text produces the same result.
+	ReAlign bool
+	// TrimLeft and TrimRight trim the padding from only the beginning or the end of each field
+	// before it is measured and written.  Setting both is the same as ReAlign.
+	TrimLeft  bool
+	TrimRight bool
+
+	// PreserveInputSpacing keeps the spacing that the input already has around its separators
+	// when it is wider than the padding.  The widest run of spaces before and after a separator
+	// in the aligned lines is measured, and each is used in place of the padding on that side of
+	// the separator if it is wider.  The spaces surrounding each field are trimmed, as with ReAlign.
+	PreserveInputSpacing bool
+
+	// MaxSplits limits each line to at most MaxSplits fields, in the same manner as strings.SplitN.
+	// The remainder of the line, including any separators, is kept as the last field.
+	// A value of 0 or less does not limit the number of fields.
+	MaxSplits int
+	// MaxColumns bounds the number of fields of each line, so that a line with a great many
+	// separators, e.g. from untrusted input, cannot use an unbounded amount of memory.  The
+	// remainder of longer lines is kept as the last field, as with MaxSplits, unless
+	// MaxColumnsError is set, in which case Align returns an error.  Columns takes precedence.
+	// A value of 0 or less does not limit the number of fields.
+	MaxColumns      int
+	MaxColumnsError bool
+
+	// EmptyValue is written in place of empty fields, e.g. "-" or "NULL", so that missing data
+	// is visible, and is measured as part of the column.  If EmptyBlank is set, fields of only
+	// whitespace are also replaced.
+	EmptyValue string
+	EmptyBlank bool
+
+	// ColumnPrefix and ColumnSuffix decorate each non-empty field of the specified column numbers,
+	// e.g. {2: "$"} or {3: " kg"}.  The decoration is part of the field when the width of its
+	// column is determined.
+	ColumnPrefix map[int]string
+	ColumnSuffix map[int]string
+
+	// Columns writes exactly Columns fields for each line.  The remainder of longer lines,
+	// including any separators, is merged into the last field, which is written without padding,
+	// and shorter lines are given empty fields.  It takes precedence over MaxSplits and FreeTail.
+	Columns int
+
+	// MarkerAlign aligns only the first separator of each line, such as the marker of a trailing
+	// comment.  The text before the separator is left justified to a common width and the text after
+	// it is written unchanged.  Lines without the separator, or with only padding before it, are
+	// written unchanged.
+	MarkerAlign bool
+
+	// SplitFromRight finds the boundaries of each line starting from the end, so that the
+	// remainder kept by MaxSplits is the first field rather than the last.  If MaxSplits is not
+	// set, each line is split into 2 fields by the last separator.
+	SplitFromRight bool
+
+	// GroupSep splits each line into groups of columns, e.g. "a,b | c,d" has the groups "a,b"
+	// and "c,d" with a GroupSep of "|".  The fields of each group are split by the separator
+	// and aligned independently of the other groups, and GroupSep is written between groups.
+	// Column filters and justification overrides do not apply to grouped lines.
+	GroupSep string
+
+	// QuoteOnOutput encloses fields that contain the output separator or the text qualifier in
+	// the text qualifier, doubling any qualifiers within the field, so that the output can be
+	// parsed again.  Fields that are already enclosed in the qualifier are left unchanged.
+	// It has no effect unless the text qualifier is on.
+	QuoteOnOutput bool
+
+	// SeparatorColumn pads the text before the first separator of each line so that the
+	// separator lands on the same display column in every line, Pad columns after the widest
+	// text preceding it, e.g. to line up the "=" signs of a settings file.  Unlike MarkerAlign,
+	// the separator and the text after it are written unchanged and the text qualifier is
+	// ignored.  Lines without the separator, or with only whitespace before it such as a comment
+	// on a line of its own, are written unchanged.  Separators within SkipRegions are ignored,
+	// so that e.g. the "#" of trailing comments can be aligned while "#" in strings is not.
+	SeparatorColumn bool
+
+	// Normalize writes the fields of each line separated only by the output separator, without
+	// any padding, e.g. to rewrite tab separated input as a clean TSV file with OutputSep("\t").
+	// The column widths are still determined and can be read with ColumnWidths.
+	Normalize bool
+
+	// Compact is the opposite of alignment: the padding surrounding each field is trimmed and
+	// the fields are written separated by the output separator and Pad, regardless of the
+	// widths of the columns.  Align writes each line as it is read, so the input is not held
+	// in memory.  Lines are otherwise selected and prepared as for alignment, e.g. by LineRange,
+	// EmptyLines, Directive and AlignMatching.  The options that depend on the column widths are
+	// not used, and CSV cannot be used.
+	Compact bool
+
+	// FlushEvery flushes the output after every FlushEvery lines are written, so that a reader
+	// of the output sees lines before they have all been written, e.g. with Compact set.
+	// A value of 0 or less flushes the output only once it has all been written.
+	FlushEvery int
+
+	// EscapeChar, if not 0, escapes the separator that follows it so that it is part of the field,
+	// e.g. with an EscapeChar of '\\' and a separator of ",", the line a\\,b,c has the fields
+	// a\\,b and c.  Text qualified fields are not affected.
+	EscapeChar byte
+	// Unescape removes EscapeChar from each escaped separator when the field is written.
+	Unescape bool
+
+	// PreserveBOM writes the UTF-8 byte order mark at the beginning of the output if the input
+	// began with one.  Otherwise, it is removed.  It is never counted as part of the first field.
+	PreserveBOM bool
+
+	// FreeTail exempts the last field of lines split into MaxSplits fields from padding, so that
+	// e.g. the message of a log line is written unchanged after its aligned timestamp and level.
+	// The tail may contain the separator and does not affect the column widths.
+	FreeTail bool
+
+	// SepWidth is the minimum display width of the output separator.  Narrower separators are
+	// followed by padding, so that output written with separators of different widths, e.g. by
+	// calls to AlignWith with different OutputSep values, still lines up.
+	SepWidth int
+
+	// CSV parses the input with encoding/csv, using the separator as the field delimiter, so that
+	// quoted fields may contain the separator, quotes and newlines.  The fields are written
+	// without their quotes, unless QuoteOnOutput is set, in which case fields that need them
+	// are quoted again.  The separator must be a single character.  The text qualifier, skip
+	// regions and the splitting options are not used.
+	CSV bool
+
+	// EmptyLines is the treatment of empty lines.  EmptyLineKeep is used by default.
+	EmptyLines EmptyLinePolicy
+
+	// KeyValue aligns key-value pairs, one per line, such as the fields of records separated by
+	// blank lines.  Each line is split into a key and a value by its first separator, the keys
+	// are padded to a common width and the values are written unpadded, e.g. "Key  : value".
+	// Lines without the separator are written unchanged.  Set SplitOnBlankLines to align the
+	// keys of each record independently.
+	KeyValue bool
+
+	// VerbatimNoSep writes lines that do not contain the separator unchanged, without affecting
+	// the width of any column and regardless of the column filter.  By default such a line is a
+	// single field in the first column.
+	VerbatimNoSep bool
+
+	// SplitOnBlankLines aligns each block of lines separated by blank lines independently of the
+	// other blocks, so that each table of a document with several tables has its own column
+	// widths.  Blank lines are written unchanged.
+	SplitOnBlankLines bool
+
+	// SuppressEmptySep replaces the output separator with padding where the field on either side
+	// of it is empty, which makes sparse tables easier to read.
+	SuppressEmptySep bool
+
+	// MinColWidth sets the minimum width of the specified column numbers.
+	MinColWidth map[int]int
+	// HeaderWidths reads minimum column widths from annotations in the first line, such as
+	// "name:20,email:30".  The annotations are removed from the output and their widths are
+	// added to MinColWidth.  Fields of the first line without an annotation are left unchanged.
+	HeaderWidths bool
+
+	// MaxColWidth truncates fields of the specified column numbers that are wider than the
+	// given display width, replacing the removed text with an ellipsis.
+	MaxColWidth map[int]int
+	// MaxTotalWidth narrows the widest columns until the aligned lines are no wider than
+	// MaxTotalWidth, as reported by TotalWidth, truncating the fields of those columns in the
+	// same manner as MaxColWidth.  The columns are narrowed one at a time, so the widest columns
+	// give up width first.  A value of 0 or less does not limit the width.
+	MaxTotalWidth int
+	// ColumnWidthPercent sets the width of the specified column numbers to a percentage of
+	// MaxTotalWidth, e.g. {2: 50} gives the second column half of it.  Narrower fields are padded
+	// and wider fields are truncated in the same manner as MaxColWidth.  The other columns are
+	// narrowed to fit the rest of MaxTotalWidth.  It is ignored if MaxTotalWidth is not set.
+	ColumnWidthPercent map[int]float64
+	// FillWidth widens the last column so that the aligned lines are FillWidth wide, as reported
+	// by TotalWidth, and pads aligned lines with fewer fields to the same width, so that every
+	// aligned line has the same right edge, e.g. for a bordered table.  Lines that are already
+	// wider are unchanged.  A value of 0 or less does not fill the lines.
+	FillWidth int
+	// FlexColumn is the column number that is widened by FillWidth in place of the last column.
+	// The other columns are sized to their content and the flex column takes the rest of
+	// FillWidth, so it is also narrowed, to no less than 1, if the lines are wider than FillWidth.
+	// Its fields are then truncated in the same manner as MaxColWidth.
+	FlexColumn int
+	// TruncateAnchor sets where fields of the specified column numbers are truncated by
+	// MaxColWidth, e.g. TruncateStart keeps the end of a long file path.  TruncateEnd is used
+	// for columns that are not specified.
+	TruncateAnchor map[int]TruncateAnchor
+
+	// ColumnDecimals rounds the numeric fields of the specified column numbers to the given
+	// number of decimal places, e.g. {3: 2} writes 1.005 as 1.00 and 7 as 7.00, before they are
+	// measured and written.  Fields that are not numbers are left unchanged.
+	ColumnDecimals map[int]int
+
+	// GroupThousands inserts ThousandsSep between each group of three digits of the integer part
+	// of numeric fields, e.g. 1234567.89 is written as 1,234,567.89.
+	GroupThousands bool
+	// ThousandsSep is the string used by GroupThousands.  If empty, "," is used.
+	ThousandsSep string
+
+	// SignColumn reserves the first column of numeric fields for the sign, so that the digits of
+	// positive and negative numbers line up.  Unsigned numbers are given a space for a sign, and
+	// right or center justified numbers are padded between the sign and the digits, as in
+	// accounting, e.g. "-   5" and "  120".
+	SignColumn bool
+
+	// ZeroPad fills the specified column numbers with leading zeros, placed after any sign,
+	// instead of spaces.  Only fields that are integers are zero padded.
+	ZeroPad map[int]bool
+
+	// Indent is written at the beginning of each output line within the LineRange.
+	// It is not included in the width of the first column.
+	Indent string
+
+	// HashColumn appends a column containing a hash of each line's original content, which
+	// can be used to detect changed rows.  The hash column follows the last field of each line.
+	HashColumn bool
+	// HashFunc computes the value of the hash column.  If nil, the CRC-32 checksum of the
+	// line is used, formatted as 8 hexadecimal digits.  It is not encoded as JSON.
+	HashFunc func(line string) string `json:"-"`
+
+	// PreservePrefix splits each line into a prefix that is kept as it is, such as the marker of
+	// a diff line or a comment, and the rest of the line, which is aligned.  The prefix is written
+	// at the beginning of the line, before any Indent, and is not part of the first column.  It
+	// is not encoded as JSON.
+	PreservePrefix func(line string) (prefix, rest string) `json:"-"`
+
+	// TabStop, if greater than 0, widens each column to the next multiple of TabStop that is
+	// greater than the column's content width, so that small edits do not change the layout.
+	TabStop int
+
+	// ElasticTabstops sizes each column by the contiguous block of lines that contain it rather
+	// than by every line of the input, so that a line with fewer fields ends the block and
+	// unrelated sections do not widen each other.  The last field of each line is not padded.
+	ElasticTabstops bool
+
+	// TrimTrailingSpace omits the padding that would follow the last field of each line.  Only
+	// padding is omitted: a right justified last field stays flush right, and the text of the
+	// field is written in full, whatever its justification.
+	TrimTrailingSpace bool
+
+	// Directive configures the Align from a first line of the form
+	// "#align: sep=| out=| pad=1 just=center qual=\"".  Each setting is optional and the
+	// directive line is not included in the output.
+	Directive bool
+
+	// HeaderLines is the number of lines at the beginning of the input (or LineRange) that are
+	// headers rather than data.  If 0, the first line is treated as a header when
+	// LooksLikeHeader reports that it appears to be one.
+	HeaderLines int
+
+	// RepeatHeaderEvery writes the header lines again, with any rule after them, before every
+	// RepeatHeaderEvery data lines after the first, e.g. for long tables viewed in a pager.  The
+	// header is given by HeaderLines.  If 0, the header is never repeated.
+	RepeatHeaderEvery int
+	// SchemaPreview writes only the header lines, aligned to the widths of the columns of the
+	// whole input, followed by a dashed rule as wide as the aligned lines, so that the layout
+	// can be checked before the data is written.  The header is given by HeaderLines, or is the
+	// first line if HeaderLines is 0.
+	SchemaPreview bool
+	// Transpose writes each data line as a block of "name: value" lines, one for each field, with
+	// the names given by the fields of the first line and the colons aligned, e.g. to inspect the
+	// records of a wide table.  The blocks are separated by a dashed rule.  Fields without a name
+	// are named by their column number.
+	Transpose bool
+	// FooterAggregate appends a footer line with an aggregate of the data lines for the specified
+	// column numbers, one of "sum", "avg", "min", "max" or "count".  Only numeric fields are
+	// aggregated, except for "count", which counts the non-empty fields.  The fields of columns
+	// without an aggregate, or without any numeric fields, are left empty.
+	FooterAggregate map[int]string
+
+	// RowNumbers prepends a right justified, sequential row number as a new first column.
+	// Only the aligned lines are numbered, so that lines outside of the LineRange or written
+	// unchanged do not take a number.  The row number column is not counted in column numbers
+	// used for filters and overrides.
+	RowNumbers bool
+	// RowNumberLabel, if set, is written in the row number column of the first (header) row
+	// and numbering begins with the second row.
+	RowNumberLabel string
 }
 
 // Grower grows by the given number of bytes n.
@@ -50,6 +572,30 @@ type Padder interface {
 	Bytes() []byte
 }
 
+// PadCharer is implemented by a Padder that fills padding with a character other than a space,
+// which may be wide, e.g. '·' or '＿'.  The padding of each field is measured in columns, so
+// that fields still align regardless of the width of the character.
+type PadCharer interface {
+	PadChar() rune
+}
+
+// PadPatterner is implemented by a Padder that fills padding with a repeating string, which is
+// truncated to the width of the padding.  It takes precedence over PadCharer.
+type PadPatterner interface {
+	PadPattern() string
+}
+
+// patternPad fills the padding of a PadGrower with a repeating pattern.
+type patternPad struct {
+	PadGrower
+	pattern string
+}
+
+// PadPattern returns the pattern that padding is filled with.
+func (p *patternPad) PadPattern() string {
+	return p.pattern
+}
+
 // PadGrower makes a string with the ability to
 // grow the underlying buffer.
 type PadGrower interface {
@@ -63,9 +609,16 @@ type fieldPad struct {
 	bytes.Buffer
 }
 
+// WidthSource identifies the field that determined the width of a column.
+type WidthSource struct {
+	Line  int    // line number (1-based) of the field
+	Value string // the field itself
+}
+
 // Align scans input and writes output with aligned text.
 type Align struct {
 	scanner      *bufio.Scanner
+	in           io.Reader
 	writer       *bufio.Writer
 	sep          string // separator string or delimiter
 	sepOut       string
@@ -73,9 +626,53 @@ type Align struct {
 	txtq         TextQualifier
 	padOpts      PaddingOpts
 	filter       []int
+	ruleAfter    []int
+	headerRows   []int // indexes of the header lines for RepeatHeaderEvery and SchemaPreview
+	dataRows     int   // number of data lines written, counted for RepeatHeaderEvery
 	filterLen    int
 	lines        []string
 	padder       PadGrower
+	lineStart    int // first line (1-based) to align; see LineRange
+	lineEnd      int // last line (1-based) to align; 0 if unbounded
+	skipRegions  []SkipRegion
+	match        *regexp.Regexp
+	leadingPad   string   // padding after each separator; set by prepareExport
+	trailingPad  string   // padding before each separator; set by prepareExport
+	numWidth     int      // width of the row number column; set by prepareExport
+	done         bool     // set once the input has been scanned
+	hashWidth    int      // width of the hash column
+	lineWidths   [][]int  // column widths of each line when ElasticTabstops is set
+	footer       []string // fields of the FooterAggregate line
+	widthSources map[int]WidthSource
+	rowColumns   []int         // number of fields of each line
+	rowIndexes   []int         // index of each line among the aligned lines, for RowNumbers
+	alignedRows  int           // number of aligned lines
+	prefixes     []string      // prefix of each line split by PreservePrefix
+	groupCounts  []map[int]int // column lengths of each group when GroupSep is set
+	sepColumn    int           // display column of the separator when SeparatorColumn is set
+	inputSpacing [2]int        // widest spacing before and after a separator, for PreserveInputSpacing
+	firstFields  []string      // fields of the first line in range
+	numericCols  map[int]bool  // whether the fields after the first line are numeric, by column
+
+	cellTransform func(row, col int, value string) string
+	lineTransform func(row int, line []byte) []byte
+	rowWrapper    func(rowIndex int, line []byte) []byte
+	wrappedRows   int          // number of aligned lines passed to rowWrapper
+	lineBuf       bytes.Buffer // the line being built for lineTransform or rowWrapper
+	splitFunc     func(line string) []string
+	csvReader     *csv.Reader
+	record        []string    // the record last read when CSV is set
+	records       [][]string  // the fields of each line when CSV is set
+	readErr       error       // the error that ended reading the input, if any
+	fitCounts     map[int]int // column widths narrowed by MaxTotalWidth
+	fixedWidths   map[int]int // column widths set by NewAlignFromSpec, keyed by column number
+	filterNames   []string
+	overrideNames map[string]Justification
+	bom           bool          // set if the input began with a UTF-8 byte order mark
+	config        *Align        // the configuration before the input was read; see Clone
+	blockCounts   []map[int]int // column lengths of each block when SplitOnBlankLines is set
+	lineBlocks    []int         // block index of each line when SplitOnBlankLines is set
+	blockFits     []map[int]int // fitCounts of each block when SplitOnBlankLines is set
 }
 
 // NewAlign creates and initializes a ScanWriter with in and out as its initial Reader and Writer
@@ -86,6 +683,7 @@ type Align struct {
 func NewAlign(in io.Reader, out io.Writer, sep string, qu TextQualifier) *Align {
 	return &Align{
 		scanner:      bufio.NewScanner(in),
+		in:           in,
 		writer:       bufio.NewWriter(out),
 		sep:          sep,
 		sepOut:       sep,
@@ -100,226 +698,2448 @@ func NewAlign(in io.Reader, out io.Writer, sep string, qu TextQualifier) *Align
 	}
 }
 
-// OutputSep sets the output separator string with outsep if a different value from the input sep is desired.
-func (a *Align) OutputSep(outsep string) {
-	a.sepOut = outsep
+// LayoutSpec is the layout of aligned columns, which can be applied to other input with
+// NewAlignFromSpec so that several files are aligned identically.
+type LayoutSpec struct {
+	Sep       string        // input separator
+	OutputSep string        // output separator
+	Qualifier TextQualifier // text qualifier of the input
+	Widths    map[int]int   // width of each column, keyed by column number
+	Padding   PaddingOpts   // justifications, padding and other options
 }
 
-// Align determines the length of each field of text around the configured delimiter and aligns all of the
-// text by the delimiter.
-func (a *Align) Align() {
-	a.columnLength()
-	a.export()
-}
+// NewAlignFromSpec creates an Align that aligns in to out with the layout of spec.  Each column
+// in spec.Widths is exactly that wide: shorter fields are padded and longer fields are
+// truncated.  Columns beyond those in spec.Widths are measured as usual.
+func NewAlignFromSpec(in io.Reader, out io.Writer, spec LayoutSpec) *Align {
+	a := NewAlign(in, out, spec.Sep, spec.Qualifier)
+	a.OutputSep(spec.OutputSep)
 
-// columnSize looks up the Align's columnCounts key with num and returns the value
-// that was set by ColumnCounts().
-// If num is not a valid key in Align.columnCounts, then -1 is returned.
-func (a *Align) columnSize(num int) int {
-	if _, ok := a.columnCounts[num]; !ok {
-		return -1
+	p := spec.Padding
+	p.MaxColWidth = make(map[int]int, len(spec.Widths)+len(p.MaxColWidth))
+	for k, v := range spec.Padding.MaxColWidth {
+		p.MaxColWidth[k] = v
 	}
-	return a.columnCounts[num]
+	for k, w := range spec.Widths {
+		p.MaxColWidth[k] = w
+	}
+	a.UpdatePadding(p)
+	a.fixedWidths = spec.Widths
+	return a
 }
 
-// UpdatePadding uses PaddingOpts p to update the Align's padding options.
-func (a *Align) UpdatePadding(p PaddingOpts) {
-	a.padOpts = p
+// LayoutSpec returns the layout of the aligned columns, which can be given to NewAlignFromSpec.
+// The widths are final, so TabStop and MaxTotalWidth are not carried over.  It is complete once
+// the input has been scanned.
+func (a *Align) LayoutSpec() LayoutSpec {
+	p := a.padOpts
+	p.TabStop = 0
+	p.MaxTotalWidth = 0
+	return LayoutSpec{
+		Sep:       a.sep,
+		OutputSep: a.sepOut,
+		Qualifier: a.txtq,
+		Widths:    a.ColumnWidths(),
+		Padding:   p,
+	}
 }
 
-// UpdatePadder sets the Align's padder implementation if a different
-// one is desired from the default.
-func (a *Align) UpdatePadder(padder PadGrower) {
-	a.padder = padder
+// Clone creates an Align that aligns in to out with the configuration of a: the separators,
+// text qualifier, padding options, column filter, line range, skip regions, rules, the pattern
+// given to AlignMatching and the functions set with SetSplitFunc, SetCellTransform,
+// SetLineTransform and SetRowWrapper.  The input and output are given to Clone, as an Align
+// reads its input only once.  If a has been aligned, the configuration it had before its input
+// was read is cloned, so that nothing determined from the input, such as the options of a
+// Directive line or the widths of HeaderWidths, is kept.  Maps and slices are copied, so the
+// clone can be reconfigured independently of a.  A padder set with UpdatePadder is shared, as it
+// cannot be copied, so a clone must be given its own padder to run concurrently with a.
+func (a *Align) Clone(in io.Reader, out io.Writer) *Align {
+	if a.config != nil {
+		a = a.config
+	}
+	c := NewAlign(in, out, a.sep, a.txtq)
+	c.sepOut = a.sepOut
+	c.padOpts = a.padOpts.clone()
+	c.FilterColumns(append([]int(nil), a.filter...))
+	c.ruleAfter = append([]int(nil), a.ruleAfter...)
+	c.lineStart, c.lineEnd = a.lineStart, a.lineEnd
+	c.skipRegions = append([]SkipRegion(nil), a.skipRegions...)
+	c.match = a.match
+	c.cellTransform = a.cellTransform
+	c.lineTransform = a.lineTransform
+	c.rowWrapper = a.rowWrapper
+	c.splitFunc = a.splitFunc
+	c.fixedWidths = copyWidths(a.fixedWidths)
+	c.filterNames = append([]string(nil), a.filterNames...)
+	if a.overrideNames != nil {
+		c.overrideNames = make(map[string]Justification, len(a.overrideNames))
+		for k, v := range a.overrideNames {
+			c.overrideNames[k] = v
+		}
+	}
+	if _, ok := a.padder.(*fieldPad); !ok {
+		c.padder = a.padder
+	}
+	return c
 }
 
-// fieldLen works in a similar manner to the standard lib function strings.Index().
-// Instead of returning the index of the first instance of sep, it returns the length
-// of s before the first index of sep.
-func fieldLen(s, sep string) int {
-	return genFieldLen(s, sep, "")
+// start marks the Align as aligned, keeping its configuration for Clone before reading the
+// input changes it.
+func (a *Align) start() {
+	if a.config == nil {
+		a.config = a.Clone(nil, nil)
+	}
+	a.done = true
 }
 
-// fieldLenEscaped works in the same way as FieldLen, but a text qualifer string can
-// be provided.  If qual is an empty string, then the behavior will be exactly the same
-// as FieldLen.
-func fieldLenEscaped(s, sep, qual string) int {
-	return genFieldLen(s, sep, qual)
+// DecodeInput wraps the input in the io.Reader returned by decode, which converts it to UTF-8,
+// e.g. charmap.Windows1252.NewDecoder().Reader from golang.org/x/text/encoding/charmap.
+// It must be called before the input is aligned.
+func (a *Align) DecodeInput(decode func(io.Reader) io.Reader) {
+	a.in = decode(a.in)
+	a.scanner = bufio.NewScanner(a.in)
 }
 
-func genFieldLen(s, sep, qual string) int {
-	i := 0
-	if qual == "" || !strings.HasPrefix(s, qual) {
-		i = strings.Index(s, sep)
-	} else {
-		i = strings.Index(s, qual+sep)
+// OutputSep sets the output separator string with outsep if a different value from the input sep is desired.
+func (a *Align) OutputSep(outsep string) {
+	a.sepOut = outsep
+}
 
-		if i == -1 {
-			return len(s)
-		}
-		return len(s[:i+len(qual)])
+// Align determines the length of each field of text around the configured delimiter and aligns all of the
+// text by the delimiter.  The input can only be aligned once; subsequent calls return ErrAligned
+// without writing any output.  Any error encountered while reading the input or writing the
+// output is returned.
+func (a *Align) Align() error {
+	if a.done {
+		return ErrAligned
 	}
-
-	if i == -1 {
-		return len(s)
+	if a.padOpts.Compact {
+		return a.compact()
 	}
-
-	return len(s[:i])
+	if err := a.columnLength(); err != nil {
+		return err
+	}
+	return a.export()
 }
 
-// columnLength scans the input and determines the maximum length of each field based on
-// the longest value for each field in all of the pertaining lines.
-// All of the lines of the io.Reader are returned as a string slice.
-func (a *Align) columnLength() {
-	a.lines = make([]string, 0)
-
-	for a.scanner.Scan() {
-		var columnNum int
-		var temp int
+// compact writes each line of the input as it is read with Compact set.  The lines are read
+// by nextLine and selected for alignment as they are by columnLength, so the column names of
+// FilterColumnsByName are resolved from the first line that is aligned.
+func (a *Align) compact() error {
+	a.start()
+	a.readErr = nil
+	a.firstFields = nil
+	if a.padOpts.CSV {
+		return errors.New("align: CSV cannot be used with Compact")
+	}
+	a.prepareExport()
 
-		line := a.scanner.Text()
+	var headerDone bool
+	row := -1 // index of the line among the lines kept
+	for n := 1; ; n++ {
+		line, prefix, keep, err := a.nextLine(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if !keep {
+			continue
+		}
+		row++
 
-		if a.txtq.On {
-			for start := 0; start < len(line); {
-				temp = fieldLenEscaped(line[start:], a.sep, a.txtq.Qualifier)
-				start += temp + len(a.sep)
-				if temp > a.columnCounts[columnNum] {
-					a.columnCounts[columnNum] = temp
-				}
-				columnNum++
-				temp = 0
-			}
-		} else {
-			for start := 0; start < len(line); {
-				temp = fieldLen(line[start:], a.sep)
-				start += temp + len(a.sep)
-				if temp > a.columnCounts[columnNum] {
-					a.columnCounts[columnNum] = temp
-				}
-				columnNum++
-				temp = 0
+		a.writer.WriteString(prefix)
+		if !a.inLineRange(row+1) || a.unalignedLine(line) {
+			a.writer.WriteString(line)
+			a.writer.WriteByte('\n')
+			a.flushEvery(row + 1)
+			continue
+		}
+		if a.padOpts.HeaderWidths && !headerDone {
+			headerDone = true
+			line = a.parseHeaderWidths(row, line)
+		}
+		if a.firstFields == nil {
+			a.firstFields = a.fields(row, line)
+			if err := a.resolveNames(); err != nil {
+				return err
 			}
 		}
-
-		a.lines = append(a.lines, line)
+		a.writer.WriteString(a.padOpts.Indent)
+		a.writeCompactLine(row, line)
+		a.flushEvery(row + 1)
+	}
+	if a.readErr != nil {
+		return a.readErr
 	}
+	return a.writer.Flush()
 }
 
-const padchar byte = ' '
-
-// export will pad each field in lines based on the Align's column counts.
-func (a *Align) export() {
-	if a.padOpts.Pad < 0 {
-		a.padOpts.Pad = 0
+// writeCompactLine writes the trimmed fields of line, at index row, separated by the output
+// separator and the surrounding padding.
+func (a *Align) writeCompactLine(row int, line string) {
+	var n int
+	for c, word := range a.fields(row, line) {
+		if a.filterLen > 0 && !contains(a.filter, c+1) {
+			continue
+		}
+		if n > 0 {
+			a.writer.WriteString(a.trailingPad)
+			a.writer.WriteString(a.sepOut)
+			a.writer.WriteString(a.leadingPad)
+		}
+		a.writer.WriteString(strings.Trim(word, string(padchar)))
+		n++
 	}
+	a.writer.WriteByte('\n')
+}
 
-	surroundingPad := make([]byte, 0, a.padOpts.Pad)
-	for i := 0; i < a.padOpts.Pad; i++ {
-		surroundingPad = append(surroundingPad, padchar)
+// Compactify undoes the alignment of the input, e.g. a pretty-printed table, by trimming the
+// padding surrounding each field and writing the fields separated by only the output separator.
+// Fields enclosed by the text qualifier keep their spaces, so that padding is distinguished from
+// the content of the fields.  It works like Align with Compact set and no padding, whatever the
+// configured PaddingOpts, which are restored afterwards.
+func (a *Align) Compactify() error {
+	if a.config == nil && !a.done {
+		a.config = a.Clone(nil, nil) // before the options are changed
 	}
+	padOpts := a.padOpts
+	defer func() {
+		a.padOpts = padOpts
+	}()
+	a.padOpts.Compact = true
+	a.padOpts.Pad, a.padOpts.PadLeft, a.padOpts.PadRight, a.padOpts.MinGap = 0, 0, 0, 0
+	return a.Align()
+}
 
-	for _, line := range a.lines {
-		words := a.splitWithQual(line, a.sep, a.txtq.Qualifier)
-
-		var columnNum int
-		var tempColumn int // used for call to pad() to incorporate column filtering
-		for _, word := range words {
-			if a.filterLen > 0 {
-				if !contains(a.filter, columnNum+1) {
-					columnNum++
-					if columnNum == len(words) {
-						a.writer.WriteString("\n")
-					}
-					continue
-				}
-			}
-
-			j := a.padOpts.Justification
+// RunOptions overrides the configuration of an Align for a single call to AlignWith.
+// Zero values leave the configured setting in place.
+type RunOptions struct {
+	OutputSep      string
+	Filter         []int
+	Justification  Justification
+	ColumnOverride map[int]Justification
+}
 
-			// override Justification for the specified columnNum in the key for the PaddingOpts.columnOverride map
-			if len(a.padOpts.ColumnOverride) > 0 {
-				for k, v := range a.padOpts.ColumnOverride {
-					if k == columnNum+1 {
-						j = v
-					}
-				}
-			}
+// AlignWith works like Align, but with opts applied over the configured output separator,
+// column filter and justification.  As an Align aligns its input only once, opts are not
+// undone afterwards; they are left out of the configuration copied by Clone, so that each
+// clone can be aligned with different RunOptions.
+func (a *Align) AlignWith(opts RunOptions) error {
+	if a.config == nil && !a.done {
+		a.config = a.Clone(nil, nil) // before opts are applied
+	}
+	if opts.OutputSep != "" {
+		a.sepOut = opts.OutputSep
+	}
+	if opts.Filter != nil {
+		a.FilterColumns(opts.Filter)
+	}
+	if opts.Justification != 0 {
+		a.padOpts.Justification = opts.Justification
+	}
+	if opts.ColumnOverride != nil {
+		a.padOpts.ColumnOverride = opts.ColumnOverride
+	}
+	return a.Align()
+}
 
-			padLength := countPadding(word, a.columnCounts[columnNum])
-			paddedWord := applyPadding(a.padder, word, string(surroundingPad), tempColumn, padLength, j)
+// AlignBytes aligns input by sep using the given text qualifier and padding options
+// and returns the aligned result.
+func AlignBytes(input []byte, sep string, qu TextQualifier, opts PaddingOpts) ([]byte, error) {
+	var out bytes.Buffer
 
-			a.padder.Reset() // empty the buffer for the next iteration.
+	a := NewAlign(bytes.NewReader(input), &out, sep, qu)
+	a.UpdatePadding(opts)
+	if err := a.Align(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
 
-			columnNum++
-			tempColumn++
+// AlignFile aligns the file at path by sep using the given text qualifier and padding options,
+// and replaces it with the aligned result.  The file is read completely before the result is
+// written to a temporary file in the same directory, which is then renamed over the original,
+// so that the file is either aligned completely or left unchanged.  The file mode is kept.
+func AlignFile(path string, sep string, qu TextQualifier, opts PaddingOpts) (err error) {
+	if path, err = filepath.EvalSymlinks(path); err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	input, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	output, err := AlignBytes(input, sep, qu, opts)
+	if err != nil {
+		return err
+	}
 
-			// Do not add a delimiter to the last field
-			// This also properly aligns the output even if there are lines with a different number of fields
-			if a.filterLen > 0 && a.filter[a.filterLen-1] == columnNum || columnNum == len(paddedWord) {
-				a.writer.Write(paddedWord)
-				a.writer.WriteByte('\n')
-				break
-			}
-			a.writer.Write(paddedWord)
-			a.writer.WriteString(a.sepOut)
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".align")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
 		}
+	}()
+	if _, err = f.Write(output); err != nil {
+		return err
 	}
-	a.writer.Flush()
+	if err = f.Chmod(info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
 }
 
-func fillWithPadding(padder Padder, length int) {
-	for i := 0; i < length; i++ {
-		padder.WriteByte(padchar)
+// WidthSources returns the field that determined the width of each column, keyed by column
+// number, which can help explain an unexpectedly wide column.  When several fields have the
+// widest length, the first is returned.  It is populated once the input has been scanned.
+func (a *Align) WidthSources() map[int]WidthSource {
+	return a.widthSources
+}
+
+// RowColumnCounts returns the number of fields of each line, in the order that the lines are
+// written, to help diagnose input with a varying number of columns.  Lines that are written
+// unchanged, and lines aligned by SeparatorColumn or GroupSep, have 0 fields.  It is populated
+// once the input has been scanned.
+func (a *Align) RowColumnCounts() []int {
+	return a.rowColumns
+}
+
+// Err returns the error that ended reading the input, if any, such as the error that ends the
+// sequence of Rows.
+func (a *Align) Err() error {
+	return a.readErr
+}
+
+// ColumnWidths returns the width of each column, keyed by column number, not including the
+// padding surrounding each field.  It is populated once the input has been scanned.
+func (a *Align) ColumnWidths() map[int]int {
+	widths := make(map[int]int, len(a.columnCounts))
+	for c, w := range a.columnCounts {
+		widths[c+1] = w
 	}
+	return widths
 }
 
-// applyPadding rebuilds word by adding padding appropriately based on the
-// desired justification, the overall padding length and the supplied surrounding
-// padding string.
-func applyPadding(padder Padder, original, surroundingPad string, columnNum, padLength int, just Justification) []byte {
-	// add surrounding pad to beginning of column (except for the 1st column)
-	if len(surroundingPad) > 0 {
-		if columnNum > 0 {
-			padder.WriteString(surroundingPad)
+// TotalWidth returns the display width of the aligned lines, which is the sum of the widths of
+// the columns that are written, including the row number and hash columns, their surrounding
+// padding and the separators between them.  It is determined once the input has been scanned.
+func (a *Align) TotalWidth() int {
+	padLeft, padRight := a.surroundingPad()
+	width := displayWidth(a.padOpts.Indent)
+
+	var n int // number of columns
+	column := func(w int) {
+		if n > 0 {
+			width += a.sepWidth() + padRight
 		}
+		width += w + padLeft
+		n++
 	}
 
-	switch just {
-	case JustifyLeft:
-		padder.WriteString(original)
-		fillWithPadding(padder, padLength)
-	case JustifyRight:
-		fillWithPadding(padder, padLength)
-		padder.WriteString(original)
-	case JustifyCenter:
-		// not much of a point to 'center' justification with such a small padding; default it if <= 2.
-		if padLength > 2 {
-			fillWithPadding(padder, (padLength - (padLength / 2)))
-			padder.WriteString(original)
-			fillWithPadding(padder, padLength/2)
-		} else {
-			padder.WriteString(original)
-			fillWithPadding(padder, padLength)
+	if a.padOpts.RowNumbers {
+		column(a.rowNumberWidth())
+	}
+	cols := a.numColumns()
+	for c := 0; c < cols; c++ {
+		if a.filterLen == 0 || contains(a.filter, c+1) {
+			column(a.columnCounts[c])
 		}
 	}
+	if a.padOpts.HashColumn {
+		column(a.hashWidth)
+	}
 
-	// add surrounding pad to end of column
-	if len(surroundingPad) > 0 {
-		padder.WriteString(surroundingPad)
+	if n > 0 && a.padOpts.TrimTrailingSpace {
+		width -= padLeft
+		if n > 1 && a.padOpts.SepHug == SepHugRight && !a.padOpts.HashColumn {
+			width -= a.hugFillTrimmed()
+		}
+	} else if n > 0 && a.padOpts.OuterPad > 0 {
+		width += a.padOpts.OuterPad - padLeft
 	}
-	return padder.Bytes()
+	return width + a.padOpts.OuterPad
+}
+
+// numColumns returns the number of columns of the widest line.
+func (a *Align) numColumns() int {
+	var cols int
+	for k := range a.columnCounts {
+		if k+1 > cols {
+			cols = k + 1
+		}
+	}
+	return cols
+}
+
+// ValidateFilter reports an error if a column number given to FilterColumns is less than 1 or
+// greater than the number of columns of the input.  It is meaningful once the input has been
+// scanned.
+func (a *Align) ValidateFilter() error {
+	cols := a.numColumns()
+	for _, c := range a.filter {
+		if c < 1 || c > cols {
+			return fmt.Errorf("align: filter column %d is out of range; the input has %d columns", c, cols)
+		}
+	}
+	return nil
+}
+
+// columnSize looks up the Align's columnCounts key with num and returns the value
+// that was set by ColumnCounts().
+// If num is not a valid key in Align.columnCounts, then -1 is returned.
+func (a *Align) columnSize(num int) int {
+	if _, ok := a.columnCounts[num]; !ok {
+		return -1
+	}
+	return a.columnCounts[num]
+}
+
+// UpdatePadding uses PaddingOpts p to update the Align's padding options.
+func (a *Align) UpdatePadding(p PaddingOpts) {
+	a.padOpts = p
+}
+
+// clone returns a copy of p that does not share its maps.
+func (p PaddingOpts) clone() PaddingOpts {
+	c := p
+	c.MinColWidth = copyWidths(p.MinColWidth)
+	c.MaxColWidth = copyWidths(p.MaxColWidth)
+	c.ColumnDecimals = copyWidths(p.ColumnDecimals)
+	c.ColumnQualifier = copyStrings(p.ColumnQualifier)
+	c.ColumnPrefix = copyStrings(p.ColumnPrefix)
+	c.ColumnSuffix = copyStrings(p.ColumnSuffix)
+	c.FooterAggregate = copyStrings(p.FooterAggregate)
+	if p.ColumnOverride != nil {
+		c.ColumnOverride = make(map[int]Justification, len(p.ColumnOverride))
+		for k, v := range p.ColumnOverride {
+			c.ColumnOverride[k] = v
+		}
+	}
+	if p.TruncateAnchor != nil {
+		c.TruncateAnchor = make(map[int]TruncateAnchor, len(p.TruncateAnchor))
+		for k, v := range p.TruncateAnchor {
+			c.TruncateAnchor[k] = v
+		}
+	}
+	if p.ZeroPad != nil {
+		c.ZeroPad = make(map[int]bool, len(p.ZeroPad))
+		for k, v := range p.ZeroPad {
+			c.ZeroPad[k] = v
+		}
+	}
+	if p.ColumnWidthPercent != nil {
+		c.ColumnWidthPercent = make(map[int]float64, len(p.ColumnWidthPercent))
+		for k, v := range p.ColumnWidthPercent {
+			c.ColumnWidthPercent[k] = v
+		}
+	}
+	return c
+}
+
+// copyWidths returns a copy of m, or nil if m is nil.
+func copyWidths(m map[int]int) map[int]int {
+	if m == nil {
+		return nil
+	}
+	c := make(map[int]int, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// copyStrings returns a copy of m, or nil if m is nil.
+func copyStrings(m map[int]string) map[int]string {
+	if m == nil {
+		return nil
+	}
+	c := make(map[int]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// surroundingPad returns the surrounding padding of the PaddingOpts, widened to the spacing of
+// the input when PreserveInputSpacing is set.
+func (a *Align) surroundingPad() (left, right int) {
+	left, right = a.padOpts.surroundingPad()
+	if a.padOpts.PreserveInputSpacing {
+		if a.inputSpacing[0] > left {
+			left = a.inputSpacing[0]
+		}
+		if a.inputSpacing[1] > right {
+			right = a.inputSpacing[1]
+		}
+	}
+	return left, right
+}
+
+// surroundingPad returns the number of padding characters to be placed before (left)
+// and after (right) the separator.
+func (p PaddingOpts) surroundingPad() (left, right int) {
+	if p.PadLeft == 0 && p.PadRight == 0 {
+		left, right = p.Pad, p.Pad
+	} else {
+		left, right = p.PadLeft, p.PadRight
+	}
+	if left < 0 {
+		left = 0
+	}
+	if right < 0 {
+		right = 0
+	}
+	if left+right < p.MinGap {
+		left = p.MinGap - right
+	}
+	return left, right
+}
+
+// SetJustifications sets the justification of successive columns from spec, in which each
+// character is 'l', 'r' or 'c' for left, right or center.  The last character also applies
+// to any remaining columns, e.g. "lrc" left justifies column 1, right justifies column 2 and
+// centers columns 3 and above.  Overrides for columns beyond the length of spec are kept.
+func (a *Align) SetJustifications(spec string) error {
+	if spec == "" {
+		return errors.New("align: empty justification spec")
+	}
+
+	overrides := make(map[int]Justification, len(a.padOpts.ColumnOverride)+len(spec))
+	for k, v := range a.padOpts.ColumnOverride {
+		overrides[k] = v
+	}
+
+	var j Justification
+	for i, c := range spec {
+		var err error
+		if j, err = ParseJustification(string(c)); err != nil {
+			return fmt.Errorf("align: invalid justification %q in spec %q", c, spec)
+		}
+		overrides[i+1] = j
+	}
+
+	a.padOpts.ColumnOverride = overrides
+	a.padOpts.Justification = j
+	return nil
+}
+
+// UpdatePadder sets the Align's padder implementation if a different
+// one is desired from the default.
+func (a *Align) UpdatePadder(padder PadGrower) {
+	a.padder = padder
+}
+
+// FieldLength returns the length of the field at the beginning of s, which is the number of
+// bytes before the first instance of sep, or len(s) if s does not contain sep.  If qualifier is
+// not empty and s begins with it, the field extends through the closing qualifier that is
+// followed by sep, so instances of sep within the qualified field are not considered.
+func FieldLength(s, sep, qualifier string) int {
+	return genFieldLen(s, sep, qualifier)
+}
+
+// fieldLen works in a similar manner to the standard lib function strings.Index().
+// Instead of returning the index of the first instance of sep, it returns the length
+// of s before the first index of sep.
+func fieldLen(s, sep string) int {
+	return genFieldLen(s, sep, "")
+}
+
+// fieldLenEscaped works in the same way as FieldLen, but a text qualifer string can
+// be provided.  If qual is an empty string, then the behavior will be exactly the same
+// as FieldLen.
+func fieldLenEscaped(s, sep, qual string) int {
+	return genFieldLen(s, sep, qual)
+}
+
+// genFieldLen returns the length of s before the first instance of sep.  If s begins with qual,
+// the field extends to the first closing qual that is followed by sep, so sep may appear
+// anywhere within the qualified field regardless of its length.
+// An empty qualified field (qual immediately followed by qual) has a length of 2*len(qual).
+func genFieldLen(s, sep, qual string) int {
+	i := 0
+	if qual == "" || !strings.HasPrefix(s, qual) {
+		i = strings.Index(s, sep)
+	} else {
+		// begin the search after the opening qualifier so that it cannot be mistaken
+		// for the closing one when the field itself begins with sep.
+		i = strings.Index(s[len(qual):], qual+sep)
+
+		if i == -1 {
+			return len(s)
+		}
+		return len(s[:len(qual)+i+len(qual)])
+	}
+
+	if i == -1 {
+		return len(s)
+	}
+
+	return len(s[:i])
+}
+
+// columnLength scans the input and determines the maximum length of each field based on
+// the longest value for each field in all of the pertaining lines.
+// All of the lines of the io.Reader are returned as a string slice.
+// Any error encountered while reading the input or applying a directive is returned.
+func (a *Align) columnLength() error {
+	a.start()
+	a.lines = make([]string, 0)
+	a.rowIndexes = nil
+	a.alignedRows = 0
+	a.lineWidths = nil
+	a.groupCounts = nil
+	a.sepColumn = 0
+	a.firstFields = nil
+	a.numericCols = make(map[int]bool)
+	a.widthSources = make(map[int]WidthSource)
+	var headerDone bool // whether the HeaderWidths annotations have been parsed
+
+	aggs := make(map[int]*aggregate, len(a.padOpts.FooterAggregate))
+	for k, name := range a.padOpts.FooterAggregate {
+		if k < 1 {
+			return fmt.Errorf("align: aggregate %q for invalid column number %d", name, k)
+		}
+		switch name {
+		case "sum", "avg", "min", "max", "count":
+			aggs[k-1] = &aggregate{name: name}
+		default:
+			return fmt.Errorf("align: unknown aggregate %q for column %d", name, k)
+		}
+	}
+	var dataLine int
+	a.bom = false
+	a.readErr = nil
+	a.blockCounts = []map[int]int{make(map[int]int)}
+	a.lineBlocks = nil
+	a.inputSpacing = [2]int{}
+	a.prefixes = nil
+
+	if err := a.startCSV(); err != nil {
+		return err
+	}
+
+	for n := 1; ; n++ {
+		line, prefix, keep, err := a.nextLine(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if !keep {
+			continue
+		}
+
+		a.lines = append(a.lines, line)
+		a.rowColumns = append(a.rowColumns, 0)
+		a.rowIndexes = append(a.rowIndexes, a.alignedRows)
+		if a.padOpts.PreservePrefix != nil {
+			a.prefixes = append(a.prefixes, prefix)
+		}
+		if a.padOpts.CSV {
+			a.records = append(a.records, a.record)
+		}
+
+		if a.padOpts.SplitOnBlankLines {
+			if strings.TrimSpace(line) == "" && len(a.blockCounts[len(a.blockCounts)-1]) > 0 {
+				a.blockCounts = append(a.blockCounts, make(map[int]int))
+			}
+			a.lineBlocks = append(a.lineBlocks, len(a.blockCounts)-1)
+		}
+
+		if a.unalignedLine(line) || !a.inLineRange(len(a.lines)) {
+			if a.padOpts.ElasticTabstops {
+				a.lineWidths = append(a.lineWidths, nil)
+			}
+			continue
+		}
+		a.alignedRows++
+
+		if a.padOpts.HeaderWidths && !headerDone {
+			headerDone = true
+			line = a.parseHeaderWidths(len(a.lines)-1, line)
+			a.lines[len(a.lines)-1] = line
+		}
+
+		if a.padOpts.SeparatorColumn {
+			if left, _, ok := a.cutSeparator(line); ok && displayWidth(left) > a.sepColumn {
+				a.sepColumn = displayWidth(left)
+			}
+			continue
+		}
+
+		if a.padOpts.GroupSep != "" {
+			a.groupLength(len(a.lines)-1, line)
+			continue
+		}
+
+		if a.padOpts.PreserveInputSpacing {
+			a.measureSpacing(len(a.lines)-1, line)
+		}
+
+		words := a.fields(len(a.lines)-1, line)
+		if m := a.maxColumns(); m > 0 && len(words) > m {
+			return fmt.Errorf("align: line %d has more than %d fields", n, m)
+		}
+		if a.padOpts.MarkerAlign && !isMarkerLine(words) {
+			words = nil
+		}
+		a.rowColumns[len(a.rowColumns)-1] = len(words)
+
+		for columnNum, word := range words {
+			if a.isTail(columnNum) {
+				continue
+			}
+			word = a.emptyValue(word)
+			if len(word) > a.columnCounts[columnNum] {
+				a.columnCounts[columnNum] = len(word)
+				a.widthSources[columnNum+1] = WidthSource{Line: len(a.lines), Value: word}
+			}
+			if block := a.blockCounts[len(a.blockCounts)-1]; len(word) > block[columnNum] {
+				block[columnNum] = len(word)
+			}
+		}
+
+		if a.padOpts.ElasticTabstops {
+			widths := make([]int, len(words))
+			for columnNum, word := range words {
+				widths[columnNum] = len(a.emptyValue(word))
+			}
+			a.lineWidths = append(a.lineWidths, widths)
+		}
+
+		if a.padOpts.HashColumn {
+			if n := len(a.hashLine(line)); n > a.hashWidth {
+				a.hashWidth = n
+			}
+		}
+
+		if dataLine++; dataLine == 1 {
+			a.firstFields = words
+		} else {
+			a.detectNumeric(words)
+		}
+
+		if dataLine > 1 && dataLine > a.padOpts.HeaderLines {
+			a.aggregate(aggs, words)
+		}
+	}
+
+	// the first line is aggregated once it is known not to be a header.
+	if a.padOpts.HeaderLines == 0 && !a.LooksLikeHeader() {
+		a.aggregate(aggs, a.firstFields)
+	}
+
+	if len(aggs) > 0 {
+		a.buildFooter(aggs)
+	}
+
+	if a.padOpts.ElasticTabstops {
+		a.elasticWidths()
+	}
+	if err := a.resolveNames(); err != nil {
+		return err
+	}
+
+	if a.padOpts.SplitOnBlankLines {
+		counts := a.columnCounts
+		a.blockFits = make([]map[int]int, len(a.blockCounts))
+		for i, block := range a.blockCounts {
+			a.columnCounts = block
+			a.blockFits[i] = a.sizeColumns()
+		}
+		a.columnCounts = counts
+	}
+	a.fitCounts = a.sizeColumns()
+	return a.readErr
+}
+
+// sizeColumns turns the lengths of columnCounts into the widths of the columns, applying
+// MinColWidth, adjustWidth, the widths of NewAlignFromSpec, MaxTotalWidth and FillWidth in that
+// order.  The widths that fields must be truncated to are returned.
+func (a *Align) sizeColumns() map[int]int {
+	for k, w := range a.padOpts.MinColWidth {
+		if k > 0 && w > a.columnCounts[k-1] {
+			a.columnCounts[k-1] = w
+		}
+	}
+	for k, w := range a.columnCounts {
+		a.columnCounts[k] = a.adjustWidth(k, w)
+	}
+	for k, w := range a.fixedWidths {
+		if k > 0 {
+			a.columnCounts[k-1] = w
+		}
+	}
+
+	a.fitCounts = nil
+	if a.padOpts.MaxTotalWidth > 0 {
+		a.percentWidths()
+		a.fitWidths()
+	}
+	if a.padOpts.FillWidth > 0 {
+		a.fillWidth()
+	}
+	return a.fitCounts
+}
+
+// percentWidths sets the width of the columns given by ColumnWidthPercent, which is at least 1.
+// The widths are recorded in fitCounts so that wider fields are truncated when they are written.
+func (a *Align) percentWidths() {
+	for k, p := range a.padOpts.ColumnWidthPercent {
+		if _, ok := a.columnCounts[k-1]; !ok || p <= 0 {
+			continue
+		}
+		w := int(float64(a.padOpts.MaxTotalWidth) * p / 100)
+		if w < 1 {
+			w = 1
+		}
+		if a.fitCounts == nil {
+			a.fitCounts = make(map[int]int)
+		}
+		a.columnCounts[k-1] = w
+		a.fitCounts[k-1] = w
+	}
+}
+
+// fitWidths narrows the widest columns, one column at a time, until TotalWidth is no more than
+// MaxTotalWidth or every column is 1 wide.  The narrowed widths are recorded in fitCounts so
+// that the fields of those columns are truncated when they are written.  The columns given by
+// ColumnWidthPercent are not narrowed.
+func (a *Align) fitWidths() {
+	for excess := a.TotalWidth() - a.padOpts.MaxTotalWidth; excess > 0; excess-- {
+		widest := -1
+		for c, w := range a.columnCounts {
+			if a.filterLen > 0 && !contains(a.filter, c+1) {
+				continue
+			}
+			if a.padOpts.ColumnWidthPercent[c+1] > 0 {
+				continue
+			}
+			if w > 1 && (widest < 0 || w > a.columnCounts[widest] || w == a.columnCounts[widest] && c < widest) {
+				widest = c
+			}
+		}
+		if widest < 0 {
+			return
+		}
+		if a.fitCounts == nil {
+			a.fitCounts = make(map[int]int)
+		}
+		a.columnCounts[widest]--
+		a.fitCounts[widest] = a.columnCounts[widest]
+	}
+}
+
+// fillWidth widens the FlexColumn, or the last column that is written, by the width that
+// TotalWidth falls short of FillWidth.  The FlexColumn is narrowed if TotalWidth exceeds it.
+func (a *Align) fillWidth() {
+	extra := a.padOpts.FillWidth - a.TotalWidth()
+	if c := a.padOpts.FlexColumn - 1; c >= 0 && c < a.numColumns() && (a.filterLen == 0 || contains(a.filter, c+1)) {
+		if extra < 0 {
+			w := a.columnCounts[c] + extra
+			if w < 1 {
+				w = 1
+			}
+			if a.fitCounts == nil {
+				a.fitCounts = make(map[int]int)
+			}
+			a.fitCounts[c] = w
+			extra = w - a.columnCounts[c]
+		}
+		a.columnCounts[c] += extra
+		return
+	}
+	if extra <= 0 {
+		return
+	}
+	for c := a.numColumns() - 1; c >= 0; c-- {
+		if a.filterLen == 0 || contains(a.filter, c+1) {
+			a.columnCounts[c] += extra
+			return
+		}
+	}
+}
+
+// startCSV prepares to read the input as CSV records if CSV is set.
+func (a *Align) startCSV() error {
+	if !a.padOpts.CSV {
+		return nil
+	}
+	if utf8.RuneCountInString(a.sep) != 1 {
+		return fmt.Errorf("align: CSV separator %q is not a single character", a.sep)
+	}
+	a.csvReader = csv.NewReader(a.in)
+	a.csvReader.Comma, _ = utf8.DecodeRuneInString(a.sep)
+	a.csvReader.FieldsPerRecord = -1
+	a.records = nil
+	return nil
+}
+
+// nextLine reads the next line of the input, numbered n (1-based), and prepares it in the same
+// way for Align, Compact and Rows: a byte order mark is removed from the first line, a Directive
+// is applied and the PreservePrefix prefix is split off.  keep is false for a line that is not
+// kept, which is the Directive line or an empty line removed by EmptyLineSkip.  io.EOF is
+// returned at the end of the input, or once reading it fails; see readErr.
+func (a *Align) nextLine(n int) (line, prefix string, keep bool, err error) {
+	line, ok := a.readLine()
+	if !ok {
+		return "", "", false, io.EOF
+	}
+
+	if n == 1 {
+		if strings.HasPrefix(line, bomUTF16LE) || strings.HasPrefix(line, bomUTF16BE) {
+			return "", "", false, ErrUTF16
+		}
+		if strings.HasPrefix(line, bomUTF8) {
+			line, a.bom = line[len(bomUTF8):], true
+		}
+	}
+
+	isDirective, err := a.directiveLine(n, line)
+	if err != nil || isDirective {
+		return "", "", false, err
+	}
+
+	if a.padOpts.PreservePrefix != nil {
+		prefix, line = a.splitPrefix(line)
+	}
+
+	if line == "" {
+		switch a.padOpts.EmptyLines {
+		case EmptyLineSkip:
+			return "", "", false, nil
+		case EmptyLineError:
+			return "", "", false, fmt.Errorf("align: line %d is empty", n)
+		}
+	}
+	return line, prefix, true, nil
+}
+
+// splitPrefix splits line, the line last read, with PreservePrefix.  With CSV set, the prefix is
+// split from the first field of the record, and the rest of the line is joined from the record.
+func (a *Align) splitPrefix(line string) (prefix, rest string) {
+	if !a.padOpts.CSV || len(a.record) == 0 {
+		return a.padOpts.PreservePrefix(line)
+	}
+	prefix, a.record[0] = a.padOpts.PreservePrefix(a.record[0])
+	return prefix, strings.Join(a.record, a.sep)
+}
+
+// readLine reads the next line of the input.  With CSV set, it reads the next record, which
+// may span several lines, and returns its fields joined by the separator.
+func (a *Align) readLine() (string, bool) {
+	if !a.padOpts.CSV {
+		if !a.scanner.Scan() {
+			a.readErr = a.scanner.Err()
+			return "", false
+		}
+		return a.scanner.Text(), true
+	}
+
+	record, err := a.csvReader.Read()
+	if err != nil {
+		if err != io.EOF {
+			a.readErr = err
+		}
+		return "", false
+	}
+	if len(a.records) == 0 && strings.HasPrefix(record[0], bomUTF8) {
+		record[0], a.bom = record[0][len(bomUTF8):], true
+	}
+	a.record = record
+	return strings.Join(record, a.sep), true
+}
+
+// directivePrefix begins a directive line; see PaddingOpts.Directive.
+const directivePrefix = "#align:"
+
+// directiveLine applies line, numbered n (1-based), if it is a directive, which is only the case
+// for the first line with Directive set, and reports whether it was one.
+func (a *Align) directiveLine(n int, line string) (bool, error) {
+	if n != 1 || !a.padOpts.Directive || !strings.HasPrefix(line, directivePrefix) {
+		return false, nil
+	}
+	return true, a.applyDirective(line)
+}
+
+// applyDirective updates the Align with the space separated key=value settings of the
+// directive line.
+func (a *Align) applyDirective(line string) error {
+	for _, setting := range strings.Fields(strings.TrimPrefix(line, directivePrefix)) {
+		kv := strings.SplitN(setting, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return fmt.Errorf("align: invalid directive setting %q", setting)
+		}
+
+		switch kv[0] {
+		case "sep":
+			if a.sepOut == a.sep {
+				a.sepOut = kv[1]
+			}
+			a.sep = kv[1]
+		case "out":
+			a.sepOut = kv[1]
+		case "pad":
+			pad, err := strconv.Atoi(kv[1])
+			if err != nil {
+				return fmt.Errorf("align: invalid directive pad %q", kv[1])
+			}
+			a.padOpts.Pad = pad
+			a.padOpts.PadLeft, a.padOpts.PadRight = 0, 0
+		case "just":
+			j, err := ParseJustification(kv[1])
+			if err != nil {
+				return fmt.Errorf("align: invalid directive justification %q", kv[1])
+			}
+			a.padOpts.Justification = j
+		case "qual":
+			a.txtq = TextQualifier{On: true, Qualifier: kv[1]}
+		default:
+			return fmt.Errorf("align: unknown directive setting %q", kv[0])
+		}
+	}
+	return nil
+}
+
+// aggregate accumulates the values of a column for FooterAggregate.
+type aggregate struct {
+	name     string
+	sum      float64
+	min, max float64
+	n        int // number of numeric values
+	count    int // number of non-empty values
+	decimals int // largest number of decimal places of the values
+}
+
+// add includes the field value s in the aggregate.
+func (agg *aggregate) add(s string) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return
+	}
+	agg.count++
+
+	if !isNumber(s) {
+		return
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return
+	}
+	if i := strings.IndexByte(s, '.'); i != -1 && len(s)-i-1 > agg.decimals {
+		agg.decimals = len(s) - i - 1
+	}
+	if agg.n == 0 || v < agg.min {
+		agg.min = v
+	}
+	if agg.n == 0 || v > agg.max {
+		agg.max = v
+	}
+	agg.sum += v
+	agg.n++
+}
+
+// String returns the formatted value of the aggregate, or an empty string if
+// there are no values to aggregate.
+func (agg *aggregate) String() string {
+	if agg.name == "count" {
+		return strconv.Itoa(agg.count)
+	}
+	if agg.n == 0 {
+		return ""
+	}
+
+	switch agg.name {
+	case "avg":
+		decimals := agg.decimals
+		if decimals < 2 {
+			decimals = 2
+		}
+		return strconv.FormatFloat(agg.sum/float64(agg.n), 'f', decimals, 64)
+	case "min":
+		return strconv.FormatFloat(agg.min, 'f', agg.decimals, 64)
+	case "max":
+		return strconv.FormatFloat(agg.max, 'f', agg.decimals, 64)
+	default:
+		return strconv.FormatFloat(agg.sum, 'f', agg.decimals, 64)
+	}
+}
+
+// thousandsSep returns the separator used by GroupThousands.
+func (a *Align) thousandsSep() string {
+	if a.padOpts.ThousandsSep == "" {
+		return ","
+	}
+	return a.padOpts.ThousandsSep
+}
+
+// unformat removes the digit grouping added by GroupThousands from s.
+func (a *Align) unformat(s string) string {
+	if !a.padOpts.GroupThousands {
+		return s
+	}
+	return strings.Replace(s, a.thousandsSep(), "", -1)
+}
+
+// aggregate adds words to the aggregates of their columns.
+func (a *Align) aggregate(aggs map[int]*aggregate, words []string) {
+	for columnNum, agg := range aggs {
+		if columnNum >= 0 && columnNum < len(words) {
+			agg.add(a.unformat(words[columnNum]))
+		}
+	}
+}
+
+// detectNumeric records whether each non-empty field of words is numeric.
+func (a *Align) detectNumeric(words []string) {
+	for columnNum, word := range words {
+		word = strings.TrimSpace(a.unformat(word))
+		if word == "" {
+			continue
+		}
+		if numeric, ok := a.numericCols[columnNum]; !ok || numeric {
+			a.numericCols[columnNum] = isNumber(word)
+		}
+	}
+}
+
+// LooksLikeHeader reports whether the first line of the input (or LineRange) appears to be a
+// header: none of its fields are numeric, while every field of at least one of those columns
+// is numeric in the following lines.  It is determined once the input has been scanned.
+func (a *Align) LooksLikeHeader() bool {
+	if len(a.firstFields) == 0 {
+		return false
+	}
+	var numericBelow bool
+	for columnNum, word := range a.firstFields {
+		word = strings.TrimSpace(a.unformat(word))
+		if isNumber(word) {
+			return false
+		}
+		if word != "" && a.numericCols[columnNum] {
+			numericBelow = true
+		}
+	}
+	return numericBelow
+}
+
+// buildFooter sets the footer fields from aggs and includes them in the column lengths.
+func (a *Align) buildFooter(aggs map[int]*aggregate) {
+	cols := a.numColumns()
+
+	a.footer = make([]string, cols)
+	for columnNum, agg := range aggs {
+		if columnNum < 0 || columnNum >= cols {
+			continue
+		}
+		v := agg.String()
+		if a.padOpts.GroupThousands {
+			v = groupThousands(v, a.thousandsSep())
+		}
+		a.footer[columnNum] = v
+		if len(v) > a.columnCounts[columnNum] {
+			a.columnCounts[columnNum] = len(v)
+		}
+	}
+}
+
+// adjustWidth applies the minimum width and tab stop of column c to the content width w.
+func (a *Align) adjustWidth(c, w int) int {
+	if min := a.padOpts.MinColWidth[c+1]; min > w {
+		w = min
+	}
+	if t := a.padOpts.TabStop; t > 0 {
+		w = (w/t + 1) * t
+	}
+	return w
+}
+
+// elasticWidths replaces the content width of each cell in lineWidths with the width of its
+// column within the block of consecutive lines that contain the column.  As with elastic
+// tabstops, only cells followed by a separator belong to a column; the last cell of each
+// line keeps its own width.
+func (a *Align) elasticWidths() {
+	for c := 0; ; c++ {
+		var found bool
+		for i := 0; i < len(a.lineWidths); {
+			if len(a.lineWidths[i]) <= c+1 {
+				i++
+				continue
+			}
+			found = true
+
+			// find the end of the block and its widest cell
+			end, max := i, 0
+			for ; end < len(a.lineWidths) && len(a.lineWidths[end]) > c+1; end++ {
+				if a.lineWidths[end][c] > max {
+					max = a.lineWidths[end][c]
+				}
+			}
+			max = a.adjustWidth(c, max)
+			for ; i < end; i++ {
+				a.lineWidths[i][c] = max
+			}
+		}
+		if !found {
+			return
+		}
+	}
+}
+
+// columnWidth returns the width of column c for the line at index i.
+func (a *Align) columnWidth(i, c int) int {
+	if a.padOpts.ElasticTabstops && i < len(a.lineWidths) && c < len(a.lineWidths[i]) {
+		return a.lineWidths[i][c]
+	}
+	if a.padOpts.SplitOnBlankLines && i < len(a.lineBlocks) {
+		return a.blockCounts[a.lineBlocks[i]][c]
+	}
+	return a.columnCounts[c]
+}
+
+// fitCount returns the width that the field at index c of the line at index i is truncated to
+// by MaxTotalWidth or FillWidth, and whether it is truncated.
+func (a *Align) fitCount(i, c int) (int, bool) {
+	fits := a.fitCounts
+	if a.padOpts.SplitOnBlankLines && i < len(a.lineBlocks) && a.lineBlocks[i] < len(a.blockFits) {
+		fits = a.blockFits[a.lineBlocks[i]]
+	}
+	w, ok := fits[c]
+	return w, ok
+}
+
+// parseHeaderWidths removes "label:width" annotations from the fields of line, which is at
+// index row, and records each width in MinColWidth.  The line is returned without the
+// annotations, which are also removed from the record of the line with CSV set.
+func (a *Align) parseHeaderWidths(row int, line string) string {
+	var words []string
+	if a.padOpts.CSV && row < len(a.records) {
+		words = a.records[row]
+	} else {
+		words = a.splitWithQual(line, a.sep, a.txtq.Qualifier)
+	}
+
+	widths := make(map[int]int, len(a.padOpts.MinColWidth)+len(words))
+	for k, v := range a.padOpts.MinColWidth {
+		widths[k] = v
+	}
+
+	for i, word := range words {
+		idx := strings.LastIndexByte(word, ':')
+		if idx == -1 {
+			continue
+		}
+		w, err := strconv.Atoi(strings.TrimSpace(word[idx+1:]))
+		if err != nil || w < 0 {
+			continue
+		}
+		words[i] = word[:idx]
+		widths[i+1] = w
+	}
+	a.padOpts.MinColWidth = widths
+
+	return strings.Join(words, a.sep)
+}
+
+// measureSpacing widens inputSpacing to the spaces before and after each separator of line,
+// which is at index row.  The spaces of fields of only spaces are not counted.
+func (a *Align) measureSpacing(row int, line string) {
+	var words []string
+	if a.padOpts.CSV && row < len(a.records) {
+		words = a.records[row]
+	} else {
+		words = a.splitWithQual(line, a.sep, a.txtq.Qualifier)
+	}
+	for i, word := range words {
+		if strings.Trim(word, string(padchar)) == "" {
+			continue
+		}
+		if n := len(word) - len(strings.TrimRight(word, string(padchar))); i < len(words)-1 && n > a.inputSpacing[0] {
+			a.inputSpacing[0] = n
+		}
+		if n := len(word) - len(strings.TrimLeft(word, string(padchar))); i > 0 && n > a.inputSpacing[1] {
+			a.inputSpacing[1] = n
+		}
+	}
+}
+
+// fields splits line into its fields by the Align's separator and text qualifier.
+// If ReAlign, PreserveInputSpacing, TrimLeft or TrimRight is set, the padding surrounding each
+// field is trimmed.
+// row is the index of line, which is passed to the cell transform.
+func (a *Align) fields(row int, line string) []string {
+	var words []string
+	if a.padOpts.CSV && row < len(a.records) {
+		words = append(words, a.records[row]...)
+	} else {
+		words = a.splitWithQual(line, a.sep, a.txtq.Qualifier)
+	}
+	if m := a.maxColumns(); m > 0 && len(words) > m && !a.padOpts.MaxColumnsError {
+		words = append(words[:m-1], strings.Join(words[m-1:], a.sep))
+	}
+	if n := a.padOpts.Columns; n > 0 && !a.padOpts.MarkerAlign && !a.padOpts.KeyValue {
+		if len(words) > n {
+			words = append(words[:n-1], strings.Join(words[n-1:], a.sep))
+		}
+		for len(words) < n {
+			words = append(words, "")
+		}
+	}
+	if a.padOpts.MarkerAlign {
+		// a qualified split of an empty line has no fields; it is not a marker line.
+		if len(words) > 0 {
+			words[0] = strings.TrimRight(words[0], string(padchar))
+		}
+		return words
+	}
+	if a.padOpts.ReAlign || a.padOpts.PreserveInputSpacing || a.padOpts.KeyValue || a.padOpts.TrimLeft && a.padOpts.TrimRight {
+		for i := range words {
+			words[i] = strings.Trim(words[i], string(padchar))
+		}
+	} else if a.padOpts.TrimLeft {
+		for i := range words {
+			words[i] = strings.TrimLeft(words[i], string(padchar))
+		}
+	} else if a.padOpts.TrimRight {
+		for i := range words {
+			words[i] = strings.TrimRight(words[i], string(padchar))
+		}
+	}
+	if a.padOpts.Unescape && a.padOpts.EscapeChar != 0 {
+		escaped := string(a.padOpts.EscapeChar) + a.sep
+		for i := range words {
+			words[i] = strings.Replace(words[i], escaped, a.sep, -1)
+		}
+	}
+	if a.cellTransform != nil {
+		for i := range words {
+			words[i] = a.cellTransform(row+1, i+1, words[i])
+		}
+	}
+	if len(a.padOpts.ColumnDecimals) > 0 {
+		for i := range words {
+			if d, ok := a.padOpts.ColumnDecimals[i+1]; ok && d >= 0 {
+				words[i] = roundDecimals(words[i], d)
+			}
+		}
+	}
+	if a.padOpts.GroupThousands {
+		for i := range words {
+			words[i] = groupThousands(words[i], a.thousandsSep())
+		}
+	}
+	if a.padOpts.SignColumn {
+		for i, w := range words {
+			if w != "" && w[0] != '-' && w[0] != '+' && isNumber(a.unformat(w)) {
+				words[i] = " " + w
+			}
+		}
+	}
+	for i := range words {
+		w, ok := a.padOpts.MaxColWidth[i+1]
+		if fit, narrowed := a.fitCount(row, i); narrowed && (!ok || fit < w) {
+			w, ok = fit, true
+		}
+		if ok {
+			words[i] = truncate(words[i], w, a.padOpts.TruncateAnchor[i+1])
+		}
+	}
+	if len(a.padOpts.ColumnPrefix) > 0 || len(a.padOpts.ColumnSuffix) > 0 {
+		for i := range words {
+			if words[i] != "" {
+				words[i] = a.padOpts.ColumnPrefix[i+1] + words[i] + a.padOpts.ColumnSuffix[i+1]
+			}
+		}
+	}
+	if a.padOpts.QuoteOnOutput && a.txtq.On {
+		for i := range words {
+			words[i] = quoteField(words[i], a.sepOut, a.txtq.Qualifier)
+		}
+	} else if a.padOpts.QuoteOnOutput && a.padOpts.CSV {
+		for i := range words {
+			words[i] = quoteField(words[i], a.sepOut, `"`)
+		}
+	}
+	return words
+}
+
+// truncate shortens s to a display width of at most w, replacing the text removed at the
+// position given by anchor with an ellipsis.
+func truncate(s string, w int, anchor TruncateAnchor) string {
+	if w <= 0 || displayWidth(s) <= w {
+		return s
+	}
+	w -= runewidth.StringWidth(ellipsis)
+	switch anchor {
+	case TruncateStart:
+		return ellipsis + suffixOfWidth(s, w)
+	case TruncateMiddle:
+		return prefixOfWidth(s, w-w/2) + ellipsis + suffixOfWidth(s, w/2)
+	default:
+		return prefixOfWidth(s, w) + ellipsis
+	}
+}
+
+// prefixOfWidth returns the longest prefix of s with a display width of at most w.
+func prefixOfWidth(s string, w int) string {
+	width := 0
+	for i, r := range s {
+		if width += runewidth.RuneWidth(r); width > w {
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// suffixOfWidth returns the longest suffix of s with a display width of at most w.
+func suffixOfWidth(s string, w int) string {
+	runes := []rune(s)
+	width, i := 0, len(runes)
+	for i > 0 {
+		if width += runewidth.RuneWidth(runes[i-1]); width > w {
+			break
+		}
+		i--
+	}
+	return string(runes[i:])
+}
+
+// quoteField encloses s in qual, doubling any instances of qual within s, if s contains sep,
+// qual or a newline and is not already enclosed in qual.
+func quoteField(s, sep, qual string) string {
+	if qual == "" {
+		return s
+	}
+	if len(s) >= 2*len(qual) && strings.HasPrefix(s, qual) && strings.HasSuffix(s, qual) {
+		return s
+	}
+	if (sep == "" || !strings.Contains(s, sep)) && !strings.Contains(s, qual) && !strings.Contains(s, "\n") {
+		return s
+	}
+	return qual + strings.Replace(s, qual, qual+qual, -1) + qual
+}
+
+// isNumber reports whether s is a decimal number with an optional sign and fractional part.
+func isNumber(s string) bool {
+	if s != "" && (s[0] == '-' || s[0] == '+') {
+		s = s[1:]
+	}
+	var digits int
+	var point bool
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] >= '0' && s[i] <= '9':
+			digits++
+		case s[i] == '.' && !point:
+			point = true
+		default:
+			return false
+		}
+	}
+	return digits > 0
+}
+
+// isInteger reports whether s is a decimal integer with an optional sign.
+func isInteger(s string) bool {
+	return isNumber(s) && !strings.Contains(s, ".")
+}
+
+// zeroPad inserts n zeros between the sign and the digits of the integer s.
+func zeroPad(s string, n int) string {
+	if n <= 0 {
+		return s
+	}
+	var sign string
+	if s[0] == '-' || s[0] == '+' {
+		sign, s = s[:1], s[1:]
+	}
+	return sign + strings.Repeat("0", n) + s
+}
+
+// roundDecimals rounds s to d decimal places.  If s is not a number, it is returned unchanged.
+// Surrounding spaces and a leading plus sign are preserved.
+func roundDecimals(s string, d int) string {
+	num := strings.TrimSpace(s)
+	if !isNumber(num) {
+		return s
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return s
+	}
+	rounded := strconv.FormatFloat(f, 'f', d, 64)
+	if num[0] == '+' {
+		rounded = "+" + rounded
+	}
+	lead := strings.Index(s, num)
+	return s[:lead] + rounded + s[lead+len(num):]
+}
+
+// groupThousands inserts sep between each group of three digits of the integer part of s.
+// If s is not a number, it is returned unchanged.  Surrounding spaces are preserved.
+func groupThousands(s, sep string) string {
+	num := strings.TrimSpace(s)
+	if !isNumber(num) {
+		return s
+	}
+	lead := strings.Index(s, num)
+
+	var sign string
+	if num[0] == '-' || num[0] == '+' {
+		sign, num = num[:1], num[1:]
+	}
+	intPart, frac := num, ""
+	if i := strings.IndexByte(num, '.'); i != -1 {
+		intPart, frac = num[:i], num[i:]
+	}
+	if len(intPart) <= 3 {
+		return s
+	}
+
+	var b strings.Builder
+	b.WriteString(s[:lead])
+	b.WriteString(sign)
+	for i := 0; i < len(intPart); i++ {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			b.WriteString(sep)
+		}
+		b.WriteByte(intPart[i])
+	}
+	b.WriteString(frac)
+	b.WriteString(s[lead+len(sign)+len(num):])
+	return b.String()
+}
+
+const padchar byte = ' '
+
+// export will pad each field in lines based on the Align's column counts, and returns any
+// error encountered while writing the output.
+func (a *Align) export() error {
+	a.prepareExport()
+	if a.exportWhole() {
+		return a.writer.Flush()
+	}
+	for i := 0; i < a.rowCount(); i++ {
+		a.exportRow(i)
+		a.flushEvery(i + 1)
+	}
+	// the output writer keeps the first error of any write, which is returned by Flush.
+	return a.writer.Flush()
+}
+
+// exportWhole writes the output of the modes that are not written row by row, Transpose and
+// SchemaPreview, and reports whether it did.
+func (a *Align) exportWhole() bool {
+	switch {
+	case a.padOpts.Transpose:
+		a.exportTransposed()
+	case a.padOpts.SchemaPreview:
+		for _, h := range a.headerRows {
+			a.exportLine(h)
+		}
+		a.writeRule()
+	default:
+		return false
+	}
+	return true
+}
+
+// exportTransposed writes the aligned lines after the first as blocks of name and value lines,
+// as described by Transpose.
+func (a *Align) exportTransposed() {
+	var names []string
+	var records [][]string
+	for i, line := range a.lines {
+		if !a.inLineRange(i+1) || a.unalignedLine(line) {
+			continue
+		}
+		if words := a.fields(i, line); names == nil {
+			names = words
+		} else {
+			records = append(records, words)
+		}
+	}
+
+	var nameWidth, valueWidth int
+	for _, words := range records {
+		for c, word := range words {
+			if a.filterLen > 0 && !contains(a.filter, c+1) {
+				continue
+			}
+			if w := displayWidth(columnName(names, c)); w > nameWidth {
+				nameWidth = w
+			}
+			if w := displayWidth(word); w > valueWidth {
+				valueWidth = w
+			}
+		}
+	}
+
+	for r, words := range records {
+		if r > 0 {
+			a.writer.WriteString(strings.Repeat("-", nameWidth+2+valueWidth))
+			a.writer.WriteByte('\n')
+		}
+		for c, word := range words {
+			if a.filterLen > 0 && !contains(a.filter, c+1) {
+				continue
+			}
+			name := columnName(names, c)
+			a.writer.WriteString(a.padOpts.Indent)
+			a.writer.WriteString(name)
+			a.writer.WriteString(strings.Repeat(string(padchar), nameWidth-displayWidth(name)))
+			a.writer.WriteString(": ")
+			a.writer.WriteString(word)
+			a.writer.WriteByte('\n')
+		}
+	}
+}
+
+// columnName returns the name of the column at index c given by names, or the column number
+// if there is none.
+func columnName(names []string, c int) string {
+	if c < len(names) && strings.TrimSpace(names[c]) != "" {
+		return strings.TrimSpace(names[c])
+	}
+	return strconv.Itoa(c + 1)
+}
+
+// exportRow writes the line at index i, preceded by the header lines if RepeatHeaderEvery data
+// lines have been written since the header was, and followed by a rule if one is set after it.
+func (a *Align) exportRow(i int) {
+	if i == 0 && a.bom && a.padOpts.PreserveBOM {
+		a.writer.WriteString(bomUTF8)
+	}
+	if a.padOpts.RepeatHeaderEvery > 0 && len(a.headerRows) > 0 && i < len(a.lines) && !contains(a.headerRows, i) &&
+		a.inLineRange(i+1) && !a.unalignedLine(a.lines[i]) {
+		if a.dataRows > 0 && a.dataRows%a.padOpts.RepeatHeaderEvery == 0 {
+			for _, h := range a.headerRows {
+				a.exportLine(h)
+				a.writeRuleAfter(h + 1)
+			}
+		}
+		a.dataRows++
+	}
+	a.exportLine(i)
+	a.writeRuleAfter(i + 1)
+}
+
+// writeRuleAfter writes a dashed line as wide as the aligned lines if row (1-based) is one of
+// the rows given to RuleAfter.
+func (a *Align) writeRuleAfter(row int) {
+	if contains(a.ruleAfter, row) {
+		a.writeRule()
+	}
+}
+
+// writeRule writes a dashed line as wide as the aligned lines, after the Indent.
+func (a *Align) writeRule() {
+	a.writer.WriteString(a.padOpts.Indent)
+	a.writer.WriteString(strings.Repeat("-", a.TotalWidth()-displayWidth(a.padOpts.Indent)))
+	a.writer.WriteByte('\n')
+}
+
+// flushEvery flushes the output after the nth line if n is a multiple of FlushEvery.
+func (a *Align) flushEvery(n int) {
+	if a.padOpts.FlushEvery > 0 && n%a.padOpts.FlushEvery == 0 {
+		a.writer.Flush()
+	}
+}
+
+// rowCount returns the number of lines to be written, including the footer.
+func (a *Align) rowCount() int {
+	if a.footer != nil {
+		return len(a.lines) + 1
+	}
+	return len(a.lines)
+}
+
+// prepareExport determines the surrounding padding, row number width and repeated header
+// lines used by exportLine and exportRow.
+func (a *Align) prepareExport() {
+	if a.padOpts.Pad < 0 {
+		a.padOpts.Pad = 0
+	}
+
+	padLeft, padRight := a.surroundingPad()
+	a.leadingPad = strings.Repeat(string(padchar), padRight)
+	a.trailingPad = strings.Repeat(string(padchar), padLeft)
+
+	if a.padOpts.PadPattern != "" {
+		a.padder = &patternPad{PadGrower: a.padder, pattern: a.padOpts.PadPattern}
+	}
+
+	a.numWidth = a.rowNumberWidth()
+	a.wrappedRows = 0
+
+	a.headerRows = nil
+	if a.padOpts.RepeatHeaderEvery > 0 || a.padOpts.SchemaPreview {
+		n := a.padOpts.HeaderLines
+		if n == 0 && (a.padOpts.SchemaPreview || a.LooksLikeHeader()) {
+			n = 1
+		}
+		for i := 0; i < len(a.lines) && len(a.headerRows) < n; i++ {
+			if a.inLineRange(i+1) && !a.unalignedLine(a.lines[i]) {
+				a.headerRows = append(a.headerRows, i)
+			}
+		}
+	}
+}
+
+// exportLine pads each field of the line at index i and writes it, after padding it to
+// FillWidth and applying the line transform and row wrapper if they are set.  The index
+// following the last line is the footer.
+func (a *Align) exportLine(i int) {
+	if a.lineTransform == nil && a.rowWrapper == nil && a.padOpts.FillWidth <= 0 {
+		a.writeLine(i)
+		return
+	}
+
+	out := a.writer
+	a.lineBuf.Reset()
+	a.writer = bufio.NewWriter(&a.lineBuf)
+	a.writeLine(i)
+	a.writer.Flush()
+	a.writer = out
+
+	if a.lineBuf.Len() > 0 {
+		line := bytes.TrimSuffix(a.lineBuf.Bytes(), []byte{'\n'})
+		aligned := i == len(a.lines) || a.inLineRange(i+1) && !a.unalignedLine(a.lines[i])
+		if a.padOpts.FillWidth > 0 && aligned {
+			if w := displayWidth(string(line)); w < a.padOpts.FillWidth {
+				line = append(line, bytes.Repeat([]byte{padchar}, a.padOpts.FillWidth-w)...)
+			}
+		}
+		if a.lineTransform != nil {
+			line = a.lineTransform(i+1, line)
+		}
+		if a.rowWrapper != nil && aligned {
+			line = a.rowWrapper(a.wrappedRows, line)
+			a.wrappedRows++
+		}
+		a.writer.Write(line)
+		a.writer.WriteByte('\n')
+	}
+}
+
+// writeLine writes the line at index i, as described by exportLine.
+func (a *Align) writeLine(i int) {
+	if i == len(a.lines) {
+		a.writer.WriteString(a.padOpts.Indent)
+		a.writeFields(i, strings.Join(a.footer, a.sep), a.footer)
+		return
+	}
+
+	if i < len(a.prefixes) {
+		a.writer.WriteString(a.prefixes[i])
+	}
+
+	line := a.lines[i]
+	if !a.inLineRange(i+1) || a.unalignedLine(line) {
+		a.writer.WriteString(line)
+		a.writer.WriteByte('\n')
+		return
+	}
+
+	a.writer.WriteString(a.padOpts.Indent)
+
+	if a.padOpts.Compact {
+		a.writeCompactLine(i, line)
+		return
+	}
+
+	if a.padOpts.SeparatorColumn {
+		a.writeSeparatorColumnLine(line)
+		return
+	}
+
+	if a.padOpts.GroupSep != "" {
+		a.writeGroupedLine(i, line)
+		return
+	}
+
+	words := a.fields(i, line)
+
+	if a.padOpts.MarkerAlign {
+		a.writeMarkerLine(line, words, a.trailingPad)
+		return
+	}
+
+	a.writeFields(i, line, words)
+}
+
+// writeFields pads and writes words, the fields of line at index i.
+func (a *Align) writeFields(i int, line string, words []string) {
+	var tempColumn int // used for call to pad() to incorporate column filtering
+	var prevEmpty bool // whether the previously written field is empty
+	firstField := true // whether no field has been written yet
+
+	a.writeOuterPad()
+
+	if a.padOpts.RowNumbers {
+		a.writeRowNumber(i, a.numWidth, a.trailingPad)
+		tempColumn++
+		firstField = false
+	}
+
+	// Do not add a delimiter after the last field that is written
+	// This also properly aligns the output even if there are lines with a different number of fields
+	last := a.lastColumn(len(words))
+
+	for columnNum, word := range words[:last+1] {
+		if a.filterLen > 0 && !contains(a.filter, columnNum+1) {
+			continue
+		}
+		if !a.padOpts.Normalize && !a.isTail(columnNum) {
+			word = a.emptyValue(word)
+		}
+		empty := strings.TrimSpace(word) == ""
+		if tempColumn > 0 && (a.padOpts.SepHug == SepHugNone || firstField) {
+			if a.padOpts.SuppressEmptySep && (prevEmpty || empty) {
+				fillWithPadding(a.padder, a.sepWidth())
+				a.writer.Write(a.padder.Bytes())
+				a.padder.Reset()
+			} else {
+				a.writeSep()
+			}
+		}
+		tempColumn++
+		prevEmpty = empty
+
+		if a.padOpts.Normalize {
+			a.writer.WriteString(word)
+			continue
+		}
+		if a.isTail(columnNum) {
+			if tempColumn > 1 {
+				a.writer.WriteString(a.leadingPad)
+			}
+			a.writer.WriteString(word)
+			continue
+		}
+
+		j := a.justification(columnNum)
+
+		padLength := countPadding(word, a.columnWidth(i, columnNum))
+		if a.padOpts.ZeroPad[columnNum+1] && isInteger(word) {
+			word, padLength = zeroPad(word, padLength), 0
+		}
+		word, padLength = a.hugSep(word, padLength, columnNum, last, firstField)
+		firstField = false
+		lead := a.leadingPad
+		if sign, digits, ok := a.hangingSign(word, j); ok {
+			if tempColumn > 1 {
+				a.padder.WriteString(a.leadingPad)
+			}
+			a.padder.WriteByte(sign)
+			word, lead = digits, ""
+		}
+		paddedWord := applyPadding(a.padder, word, lead, a.trailingPad, tempColumn-1, padLength, j, a.padOpts.CenterBias)
+
+		if columnNum == last && a.padOpts.TrimTrailingSpace && !a.padOpts.HashColumn {
+			paddedWord = paddedWord[:len(paddedWord)-trailingPadLen(a.padder, a.trailingPad, padLength, j, a.padOpts.CenterBias)]
+		} else if columnNum == last && a.padOpts.OuterPad > 0 && !a.padOpts.HashColumn {
+			paddedWord = paddedWord[:len(paddedWord)-len(a.trailingPad)]
+		}
+		a.writer.Write(paddedWord)
+
+		a.padder.Reset() // empty the buffer for the next iteration.
+	}
+	a.endLine(line, tempColumn, tempColumn > 0)
+}
+
+// justification returns the Justification of the column at index c, given by ColumnOverride,
+// PivotColumn or Justification in that order of precedence.
+func (a *Align) justification(c int) Justification {
+	// override Justification for the specified column number in the key of the ColumnOverride map
+	if j, ok := a.padOpts.ColumnOverride[c+1]; ok {
+		return j
+	}
+	if p := a.padOpts.PivotColumn; p > 0 {
+		switch {
+		case c+1 < p:
+			return JustifyRight
+		case c+1 > p:
+			return JustifyLeft
+		}
+		return JustifyCenter
+	}
+	return a.padOpts.Justification
+}
+
+// hugSep attaches the output separator to word and adjusts its padLength when SepHug is set.
+// With SepHugLeft, the separator follows every field but the last field of the line, which is
+// padded to the same width unless it is in the last column.  With SepHugRight, it precedes
+// every field but the first.
+func (a *Align) hugSep(word string, padLength, columnNum, last int, first bool) (string, int) {
+	fill := a.sepWidth() - displayWidth(a.sepOut)
+	switch {
+	case a.padOpts.SepHug == SepHugLeft && columnNum < last:
+		return word + a.sepOut, padLength + fill
+	case a.padOpts.SepHug == SepHugLeft && columnNum < a.lastColumn(a.numColumns()):
+		return word, padLength + a.sepWidth()
+	case a.padOpts.SepHug == SepHugRight && !first:
+		return a.sepOut + word, padLength + fill
+	}
+	return word, padLength
+}
+
+// hugFillTrimmed returns the padding that fills SepWidth after the widest field of the last
+// column with SepHugRight set, which TrimTrailingSpace removes.
+func (a *Align) hugFillTrimmed() int {
+	fill := a.sepWidth() - displayWidth(a.sepOut)
+	switch a.justification(a.lastColumn(a.numColumns())) {
+	case JustifyLeft:
+		return fill
+	case JustifyCenter:
+		_, after := centerPadding(fill, a.padOpts.CenterBias)
+		return after
+	}
+	return 0
+}
+
+// emptyValue returns EmptyValue in place of an empty word, or a word of only whitespace if
+// EmptyBlank is set.  Otherwise, word is returned.
+func (a *Align) emptyValue(word string) string {
+	if a.padOpts.EmptyValue == "" {
+		return word
+	}
+	if word == "" || a.padOpts.EmptyBlank && strings.TrimSpace(word) == "" {
+		return a.padOpts.EmptyValue
+	}
+	return word
+}
+
+// hangingSign splits the sign reserved by SignColumn from the digits of word, so that the
+// padding of a right or center justified number can be written between them.
+func (a *Align) hangingSign(word string, j Justification) (byte, string, bool) {
+	if !a.padOpts.SignColumn || j == JustifyLeft || len(word) < 2 {
+		return 0, "", false
+	}
+	if sign := word[0]; (sign == '-' || sign == '+' || sign == ' ') && isNumber(a.unformat(word[1:])) {
+		return sign, word[1:], true
+	}
+	return 0, "", false
+}
+
+// lastColumn returns the index of the last of n fields that is written, which
+// depends on the column filter.  If no field is written, -1 is returned.
+func (a *Align) lastColumn(n int) int {
+	if a.filterLen == 0 {
+		return n - 1
+	}
+	for c := n - 1; c >= 0; c-- {
+		if contains(a.filter, c+1) {
+			return c
+		}
+	}
+	return -1
+}
+
+// endLine terminates an output line, first writing the hash column for line if HashColumn
+// is set.  column is the output column of the hash and needSep reports whether the
+// output separator must be written before it.
+func (a *Align) endLine(line string, column int, needSep bool) {
+	if a.padOpts.HashColumn {
+		if needSep {
+			a.writeSep()
+		}
+		h := a.hashLine(line)
+		if a.padOpts.Normalize {
+			a.writer.WriteString(h)
+			a.writer.WriteByte('\n')
+			return
+		}
+		padLength := countPadding(h, a.hashWidth)
+		paddedHash := applyPadding(a.padder, h, a.leadingPad, a.trailingPad, column, padLength, JustifyLeft, a.padOpts.CenterBias)
+		if a.padOpts.TrimTrailingSpace {
+			paddedHash = paddedHash[:len(paddedHash)-trailingPadLen(a.padder, a.trailingPad, padLength, JustifyLeft, a.padOpts.CenterBias)]
+		} else if a.padOpts.OuterPad > 0 {
+			paddedHash = paddedHash[:len(paddedHash)-len(a.trailingPad)]
+		}
+		a.writer.Write(paddedHash)
+		a.padder.Reset()
+	}
+	if (column > 0 || a.padOpts.HashColumn) && !a.padOpts.TrimTrailingSpace {
+		a.writeOuterPad()
+	}
+	a.writer.WriteByte('\n')
+}
+
+// writeOuterPad writes the OuterPad margin.
+func (a *Align) writeOuterPad() {
+	if a.padOpts.OuterPad > 0 {
+		fillWithPadding(a.padder, a.padOpts.OuterPad)
+		a.writer.Write(a.padder.Bytes())
+		a.padder.Reset()
+	}
+}
+
+// hashLine returns the value of the hash column for line.
+func (a *Align) hashLine(line string) string {
+	if a.padOpts.HashFunc != nil {
+		return a.padOpts.HashFunc(line)
+	}
+	return fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(line)))
+}
+
+// Reader returns an io.Reader from which the aligned text can be read.  The input is
+// scanned on the first call to Read and each line is padded as the caller reads, so the
+// io.Writer given to NewAlign is not used.
+func (a *Align) Reader() io.Reader {
+	return &alignReader{a: a}
+}
+
+// alignReader pads the lines of its Align on demand.
+type alignReader struct {
+	a       *Align
+	buf     bytes.Buffer
+	row     int
+	started bool
+}
+
+// Read reads the next len(p) bytes of aligned text into p.
+func (r *alignReader) Read(p []byte) (int, error) {
+	if !r.started {
+		r.started = true
+		if r.a.done {
+			return 0, ErrAligned
+		}
+		if err := r.a.columnLength(); err != nil {
+			return 0, err
+		}
+		r.a.writer = bufio.NewWriter(&r.buf)
+		r.a.prepareExport()
+		if r.a.exportWhole() {
+			r.row = r.a.rowCount()
+		}
+	}
+
+	for r.buf.Len() < len(p) && r.row < r.a.rowCount() {
+		r.a.exportRow(r.row)
+		r.row++
+	}
+	r.a.writer.Flush()
+
+	if r.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return r.buf.Read(p)
+}
+
+// Stream aligns input that arrives over time, such as the output of a running process.  Lines
+// are held until the block they belong to is complete, which is when a blank line is written
+// or Flush is called, so that a later line with a wider field or more fields still aligns
+// with the lines before it.  A Stream is an io.Writer.
+type Stream struct {
+	out     io.Writer
+	sep     string
+	sepOut  string
+	txtq    TextQualifier
+	padOpts PaddingOpts
+	lines   []string
+	partial []byte // text written after the last newline
+}
+
+// NewStream creates a Stream that writes each aligned block to out.  The options are the same
+// as those of an Align created with NewAlign.
+func NewStream(out io.Writer, sep string, qu TextQualifier) *Stream {
+	return &Stream{
+		out:     out,
+		sep:     sep,
+		sepOut:  sep,
+		txtq:    qu,
+		padOpts: PaddingOpts{Justification: JustifyLeft, Pad: 1},
+	}
+}
+
+// UpdatePadding sets the padding options used for each block.
+func (s *Stream) UpdatePadding(p PaddingOpts) {
+	s.padOpts = p
+}
+
+// OutputSep sets the output separator used for each block.
+func (s *Stream) OutputSep(outsep string) {
+	s.sepOut = outsep
+}
+
+// Write adds the lines of p to the current block.  A line without a trailing newline is held
+// until the rest of it is written.
+func (s *Stream) Write(p []byte) (int, error) {
+	s.partial = append(s.partial, p...)
+	for {
+		i := bytes.IndexByte(s.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimSuffix(string(s.partial[:i]), "\r")
+		s.partial = s.partial[i+1:]
+		if err := s.WriteLine(line); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+// WriteLine adds line to the current block.  A blank line completes the block, which is
+// aligned and written, followed by the blank line.
+func (s *Stream) WriteLine(line string) error {
+	if strings.TrimSpace(line) != "" {
+		s.lines = append(s.lines, line)
+		return nil
+	}
+	if err := s.flushBlock(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(s.out, line+"\n")
+	return err
+}
+
+// Aligned returns the lines of the current block as they would be written by Flush, without
+// completing the block.  The widths are determined from every line written so far, so it can
+// be used to redraw a live table as lines arrive.
+func (s *Stream) Aligned() ([]byte, error) {
+	var out bytes.Buffer
+	if len(s.lines) == 0 {
+		return out.Bytes(), nil
+	}
+	a := NewAlign(strings.NewReader(strings.Join(s.lines, "\n")), &out, s.sep, s.txtq)
+	a.UpdatePadding(s.padOpts)
+	a.OutputSep(s.sepOut)
+	if err := a.Align(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Flush aligns and writes the lines of the current block, including any partial line, and
+// begins a new block.
+func (s *Stream) Flush() error {
+	if len(s.partial) > 0 {
+		s.lines = append(s.lines, string(s.partial))
+		s.partial = nil
+	}
+	return s.flushBlock()
+}
+
+// flushBlock aligns and writes the lines of the current block and begins a new block.
+func (s *Stream) flushBlock() error {
+	b, err := s.Aligned()
+	if err != nil {
+		return err
+	}
+	s.lines = s.lines[:0]
+	_, err = s.out.Write(b)
+	return err
+}
+
+// writeMarkerLine writes a line split by MarkerAlign, padding the text before the
+// separator so that the separators of each line align.
+func (a *Align) writeMarkerLine(line string, words []string, trailingPad string) {
+	if !isMarkerLine(words) {
+		a.writer.WriteString(line)
+		a.writer.WriteByte('\n')
+		return
+	}
+
+	a.writer.Write(applyPadding(a.padder, words[0], "", trailingPad, 0, countPadding(words[0], a.columnCounts[0]), JustifyLeft, a.padOpts.CenterBias))
+	a.padder.Reset()
+	a.writeSep()
+	a.writer.WriteString(words[1])
+	a.writer.WriteByte('\n')
+}
+
+// cutSeparator slices line around its first separator, returning the text before it with any
+// trailing padding removed and the remainder of the line beginning with the separator.  ok is
+// false if line has no separator outside of the skip regions, or only whitespace before it.
+func (a *Align) cutSeparator(line string) (left, right string, ok bool) {
+	i := strings.Index(line, a.sep)
+	if len(a.skipRegions) > 0 {
+		if words := splitSkipping(line, a.sep, 2, a.skipRegions); len(words) == 2 {
+			i = len(words[0])
+		} else {
+			i = -1
+		}
+	}
+	if i < 0 || strings.TrimSpace(line[:i]) == "" {
+		return line, "", false
+	}
+	return strings.TrimRight(line[:i], string(padchar)), line[i:], true
+}
+
+// writeSeparatorColumnLine writes a line with SeparatorColumn set, padding the text before the
+// first separator so that the separator lands on the common separator column.
+func (a *Align) writeSeparatorColumnLine(line string) {
+	left, right, ok := a.cutSeparator(line)
+	if !ok {
+		a.writer.WriteString(line)
+		a.writer.WriteByte('\n')
+		return
+	}
+
+	a.writer.WriteString(left)
+	fillWithPadding(a.padder, a.sepColumn-displayWidth(left)+a.padOpts.Pad)
+	a.writer.Write(a.padder.Bytes())
+	a.padder.Reset()
+	a.writer.WriteString(right)
+	a.writer.WriteByte('\n')
+}
+
+// groupLength determines the length of the fields of each group of line split by GroupSep.
+func (a *Align) groupLength(row int, line string) {
+	for g, group := range strings.Split(line, a.padOpts.GroupSep) {
+		if g == len(a.groupCounts) {
+			a.groupCounts = append(a.groupCounts, make(map[int]int))
+		}
+		for columnNum, word := range a.fields(row, group) {
+			if len(word) > a.groupCounts[g][columnNum] {
+				a.groupCounts[g][columnNum] = len(word)
+			}
+		}
+	}
+}
+
+// writeGroupedLine pads the fields of each group of line split by GroupSep and writes it.
+func (a *Align) writeGroupedLine(row int, line string) {
+	groups := strings.Split(line, a.padOpts.GroupSep)
+	for g, group := range groups {
+		if g > 0 {
+			a.writer.WriteString(a.padOpts.GroupSep)
+		}
+
+		words := a.fields(row, group)
+		for columnNum, word := range words {
+			if columnNum > 0 {
+				a.writeSep()
+			}
+			padLength := countPadding(word, a.groupCounts[g][columnNum])
+			paddedWord := applyPadding(a.padder, word, a.leadingPad, a.trailingPad, g+columnNum, padLength, a.padOpts.Justification, a.padOpts.CenterBias)
+			if a.padOpts.TrimTrailingSpace && g == len(groups)-1 && columnNum == len(words)-1 {
+				paddedWord = paddedWord[:len(paddedWord)-trailingPadLen(a.padder, a.trailingPad, padLength, a.padOpts.Justification, a.padOpts.CenterBias)]
+			}
+			a.writer.Write(paddedWord)
+			a.padder.Reset()
+		}
+
+		// pad the columns this line is missing so that the next group still aligns.
+		if g < len(groups)-1 {
+			for columnNum := len(words); columnNum < len(a.groupCounts[g]); columnNum++ {
+				fillWithPadding(a.padder, a.sepWidth()+len(a.leadingPad)+a.groupCounts[g][columnNum]+len(a.trailingPad))
+			}
+			a.writer.Write(a.padder.Bytes())
+			a.padder.Reset()
+		}
+	}
+	a.writer.WriteByte('\n')
+}
+
+// isMarkerLine reports whether the fields of a line split by MarkerAlign have text
+// preceding the separator.
+func isMarkerLine(words []string) bool {
+	return len(words) == 2 && words[0] != ""
+}
+
+// rowNumber returns the row number column value for the aligned line at index i among the
+// aligned lines.
+func (a *Align) rowNumber(i int) string {
+	if a.padOpts.RowNumberLabel == "" {
+		return strconv.Itoa(i + 1)
+	}
+	if i == 0 {
+		return a.padOpts.RowNumberLabel
+	}
+	return strconv.Itoa(i)
+}
+
+// rowNumberWidth returns the width of the row number column, which is
+// the length of the largest row number or the label, whichever is longer.
+// If RowNumbers is not set, 0 is returned.
+func (a *Align) rowNumberWidth() int {
+	if !a.padOpts.RowNumbers || a.alignedRows == 0 {
+		return 0
+	}
+	width := len(a.rowNumber(a.alignedRows - 1))
+	if n := len(a.padOpts.RowNumberLabel); n > width {
+		width = n
+	}
+	return width
+}
+
+// writeRowNumber writes the right justified row number for the line at index i, followed by the
+// output separator with SepHugLeft set.
+func (a *Align) writeRowNumber(i, width int, trailingPad string) {
+	var num string
+	if i < len(a.rowIndexes) {
+		num = a.rowNumber(a.rowIndexes[i])
+	}
+	padLength := countPadding(num, width)
+	if a.padOpts.SepHug == SepHugLeft {
+		num, padLength = num+a.sepOut, padLength+a.sepWidth()-displayWidth(a.sepOut)
+	}
+	paddedNum := applyPadding(a.padder, num, "", trailingPad, 0, padLength, JustifyRight, a.padOpts.CenterBias)
+	a.writer.Write(paddedNum)
+	a.padder.Reset()
+}
+
+// padding is written in bulk by fillWithPadding.
+var padding = bytes.Repeat([]byte{padchar}, 256)
+
+// fillWithPadding writes length columns of padding to padder.  If padder is a PadPatterner or
+// a PadCharer, as much of its pattern or as many of its padding characters as fit are written,
+// followed by spaces for any remainder.
+func fillWithPadding(padder Padder, length int) {
+	if fill := patternFill(padder, length); fill != "" {
+		padder.WriteString(fill)
+		length -= displayWidth(fill)
+	} else if r, w := padRune(padder); r != rune(padchar) {
+		c := string(r)
+		for n := length / w; n > 0; n-- {
+			padder.WriteString(c)
+		}
+		length %= w
+	}
+	for length > 0 {
+		n := length
+		if n > len(padding) {
+			n = len(padding)
+		}
+		padder.Write(padding[:n])
+		length -= n
+	}
+}
+
+// patternFill returns the pattern of padder repeated and truncated to a display width of at
+// most length, or "" if padder is not a PadPatterner.
+func patternFill(padder Padder, length int) string {
+	p, ok := padder.(PadPatterner)
+	if !ok || length <= 0 {
+		return ""
+	}
+	pattern := p.PadPattern()
+	w := displayWidth(pattern)
+	if w == 0 {
+		return ""
+	}
+	fill := strings.Repeat(pattern, length/w+1)
+	return prefixOfWidth(fill, length)
+}
+
+// padRune returns the character that padder is filled with and its display width.
+func padRune(padder Padder) (rune, int) {
+	if p, ok := padder.(PadCharer); ok {
+		r := p.PadChar()
+		if w := runewidth.RuneWidth(r); w > 0 {
+			return r, w
+		}
+	}
+	return rune(padchar), 1
+}
+
+// padBytes returns the number of bytes fillWithPadding writes to padder for length columns.
+func padBytes(padder Padder, length int) int {
+	if fill := patternFill(padder, length); fill != "" {
+		return len(fill) + length - displayWidth(fill)
+	}
+	r, w := padRune(padder)
+	return length/w*utf8.RuneLen(r) + length%w
+}
+
+// applyPadding rebuilds word by adding padding appropriately based on the
+// desired justification, the overall padding length and the supplied leading
+// and trailing surrounding padding strings.
+func applyPadding(padder Padder, original, leadingPad, trailingPad string, columnNum, padLength int, just Justification, bias CenterBias) []byte {
+	// add surrounding pad to beginning of column (except for the 1st column)
+	if len(leadingPad) > 0 {
+		if columnNum > 0 {
+			padder.WriteString(leadingPad)
+		}
+	}
+
+	switch just {
+	case JustifyLeft:
+		padder.WriteString(original)
+		fillWithPadding(padder, padLength)
+	case JustifyRight:
+		fillWithPadding(padder, padLength)
+		padder.WriteString(original)
+	case JustifyCenter:
+		before, after := centerPadding(padLength, bias)
+		fillWithPadding(padder, before)
+		padder.WriteString(original)
+		fillWithPadding(padder, after)
+	}
+
+	// add surrounding pad to end of column
+	if len(trailingPad) > 0 {
+		padder.WriteString(trailingPad)
+	}
+	return padder.Bytes()
+}
+
+// trailingPadLen returns the number of bytes of padding that applyPadding writes to padder after
+// the original text for the given trailing pad, padding length, justification and center bias.
+func trailingPadLen(padder Padder, trailingPad string, padLength int, just Justification, bias CenterBias) int {
+	if padLength < 0 {
+		padLength = 0
+	}
+	n := len(trailingPad)
+	switch just {
+	case JustifyLeft:
+		n += padBytes(padder, padLength)
+	case JustifyCenter:
+		_, after := centerPadding(padLength, bias)
+		n += padBytes(padder, after)
+	}
+	return n
+}
+
+// centerPadding divides padLength into the padding before and after centered text.  An odd
+// padding character is placed before the text with CenterBiasRight and after it with
+// CenterBiasLeft.
+func centerPadding(padLength int, bias CenterBias) (before, after int) {
+	if padLength <= 0 {
+		return 0, 0
+	}
+	if bias == CenterBiasLeft {
+		return padLength / 2, padLength - padLength/2
+	}
+	return padLength - padLength/2, padLength / 2
 }
 
 // determines the length of the padding needed.
 func countPadding(s string, count int) int {
 	padLength := count - len(s)
-	rCount, wordLen := runewidth.StringWidth(s), len(s)
+	rCount, wordLen := displayWidth(s), len(s)
 	if rCount < wordLen {
 		padLength += wordLen - rCount
 	}
 	return padLength
 }
 
+// displayWidth returns the number of columns s occupies when displayed.  Printable ASCII
+// is measured by its length, so that the width tables are only consulted for other text.
+func displayWidth(s string) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] < ' ' || s[i] > '~' {
+			return runewidth.StringWidth(s)
+		}
+	}
+	return len(s)
+}
+
 // prepends padding.
 func leadingPad(sb *strings.Builder, padLen int) {
 	for i := 0; i < padLen; i++ {
@@ -334,15 +3154,100 @@ func trailingPad(sb *strings.Builder, padLen int) {
 	}
 }
 
+// SetSplitFunc sets a function that splits each line into its fields in place of the
+// separator, text qualifier and the other splitting options, e.g. to split shell words.
+// The fields it returns are measured and padded as usual and are written separated by the
+// output separator.  A nil fn restores the built-in splitting.
+func (a *Align) SetSplitFunc(fn func(line string) []string) {
+	a.splitFunc = fn
+}
+
+// SetCellTransform sets a function that replaces the value of each field before it is padded,
+// e.g. to mask secrets or reformat dates.  It is called with the line and column numbers of
+// the field, and its result is used both to determine the column widths and for the output.
+// For lines split by GroupSep, col is the column number within the group.  Fields of
+// MarkerAlign lines are not transformed.
+func (a *Align) SetCellTransform(fn func(row, col int, value string) string) {
+	a.cellTransform = fn
+}
+
+// SetLineTransform sets a function that is applied to each aligned line, without its newline,
+// just before it is written, e.g. to colorize a whole row.  row is the line number (1-based);
+// the footer follows the last line.
+func (a *Align) SetLineTransform(fn func(row int, line []byte) []byte) {
+	a.lineTransform = fn
+}
+
+// SetRowWrapper sets a function that wraps each aligned row, without its newline, once it has
+// been rendered, e.g. in the ANSI codes of alternating colors to stripe a table.  rowIndex counts
+// the aligned rows from 0 in the order they are written, including repeated header lines and
+// the footer, so that alternate rows can be told apart; lines that are written unchanged and
+// rules are neither wrapped nor counted.  It is applied after the line transform.
+func (a *Align) SetRowWrapper(fn func(rowIndex int, line []byte) []byte) {
+	a.rowWrapper = fn
+}
+
+// Split splits line into its fields by the Align's separator in the same manner as Align does,
+// considering the text qualifier, skip regions, MaxSplits and SplitFromRight, or with the
+// function set by SetSplitFunc.  The fields are returned as found in line, including any
+// qualifiers and surrounding padding.
+func (a *Align) Split(line string) []string {
+	return a.splitWithQual(line, a.sep, a.txtq.Qualifier)
+}
+
 // splitWithQual basically works like the standard strings.Split() func, but will consider a text qualifier if set.
 func (a *Align) splitWithQual(s, sep, qual string) []string {
-	if !a.txtq.On {
+	if a.splitFunc != nil {
+		if words := a.splitFunc(s); len(words) > 0 {
+			return words
+		}
+		return []string{""}
+	}
+	if a.padOpts.SplitFromRight {
+		n := a.maxSplits()
+		if n <= 0 {
+			n = 2
+		}
+		return joinLeading(a.splitN(s, sep, qual, 0), sep, n)
+	}
+	return a.splitN(s, sep, qual, a.maxSplits())
+}
+
+// splitN splits s into at most n fields (unlimited if n <= 0) by sep, considering the text
+// qualifier and skip regions.
+func (a *Align) splitN(s, sep, qual string, n int) []string {
+	if len(a.skipRegions) > 0 {
+		return splitSkipping(s, sep, n, a.skipRegions)
+	}
+	esc := a.padOpts.EscapeChar
+	if !a.txtq.On && len(a.padOpts.ColumnQualifier) == 0 {
+		if esc != 0 {
+			return splitEscaped(s, sep, esc, n)
+		}
+		if n > 0 {
+			return strings.SplitN(s, sep, n)
+		}
 		return strings.Split(s, sep) // use standard Split() method if no qualifier is considered
 	}
-	var words = make([]string, 0, strings.Count(s, sep))
+	size := strings.Count(s, sep) + 1
+	if n > 0 && n < size {
+		size = n
+	}
+	var words = make([]string, 0, size)
 
-	for start := 0; start < len(s); {
-		count := genFieldLen(s[start:], sep, qual)
+	// a separator at the end of s is followed by an empty field, as with strings.Split.
+	for start := 0; start < len(s) || start > 0 && start == len(s); {
+		if n > 0 && len(words) == n-1 {
+			words = append(words, s[start:])
+			break
+		}
+		// padding from a previous alignment would hide the opening qualifier.
+		if a.paddedFields() {
+			for start < len(s)-1 && s[start] == padchar {
+				start++
+			}
+		}
+		count := a.qualFieldLen(s[start:], sep, a.columnQual(len(words), qual))
 		words = append(words, s[start:start+count])
 		start += count + len(sep)
 	}
@@ -350,12 +3255,350 @@ func (a *Align) splitWithQual(s, sep, qual string) []string {
 	return words
 }
 
+// columnQual returns the text qualifier of column c (0-based), which is qual unless it is
+// overridden by ColumnQualifier.
+func (a *Align) columnQual(c int, qual string) string {
+	if q, ok := a.padOpts.ColumnQualifier[c+1]; ok {
+		return q
+	}
+	if !a.txtq.On {
+		return ""
+	}
+	return qual
+}
+
+// qualFieldLen returns the length of the field at the beginning of s, considering the text
+// qualifier and escape character.  Qualifiers of a single character are found by rune rather
+// than by string.
+func (a *Align) qualFieldLen(s, sep, qual string) int {
+	open, close := a.txtq.Open, a.txtq.Close
+	if !a.txtq.On || qual != a.txtq.Qualifier {
+		open, close = 0, 0 // the column has its own qualifier
+	}
+	if open == 0 && len(qual) == 1 && qual[0] < utf8.RuneSelf {
+		open = rune(qual[0])
+	}
+	if close == 0 {
+		close = open
+	}
+
+	var qualified bool
+	if open != 0 {
+		r, _ := utf8.DecodeRuneInString(s)
+		qualified = r == open
+	} else {
+		qualified = qual != "" && strings.HasPrefix(s, qual)
+	}
+
+	if esc := a.padOpts.EscapeChar; esc != 0 && !qualified {
+		return escapedFieldLen(s, sep, esc)
+	}
+	if open == 0 {
+		return genFieldLen(s, sep, qual)
+	}
+	if !qualified {
+		if i := strings.Index(s, sep); i >= 0 {
+			return i
+		}
+		return len(s)
+	}
+	return runeFieldLen(s, sep, open, close, a.paddedFields())
+}
+
+// paddedFields reports whether the fields may be surrounded by padding from a previous
+// alignment, which is skipped when the text qualifiers of the fields are found.
+func (a *Align) paddedFields() bool {
+	return a.padOpts.ReAlign || a.padOpts.TrimLeft || a.padOpts.Compact
+}
+
+// runeFieldLen returns the length of s, which begins with open, through the first close that is
+// followed by sep, or len(s) if there is none.  If padded, the close may be followed by padding
+// before sep, which is included in the length.
+func runeFieldLen(s, sep string, open, close rune, padded bool) int {
+	i := utf8.RuneLen(open)
+	for {
+		j := strings.IndexRune(s[i:], close)
+		if j < 0 {
+			return len(s)
+		}
+		i += j + utf8.RuneLen(close)
+		k := i
+		if padded {
+			for k < len(s) && s[k] == padchar {
+				k++
+			}
+			if k == len(s) {
+				return k
+			}
+		}
+		if strings.HasPrefix(s[k:], sep) {
+			return k
+		}
+	}
+}
+
+// splitEscaped splits s into at most n fields (unlimited if n <= 0) by each instance of sep
+// that is not preceded by esc.
+func splitEscaped(s, sep string, esc byte, n int) []string {
+	var words []string
+	start := 0
+	for n <= 0 || len(words) < n-1 {
+		count := escapedFieldLen(s[start:], sep, esc)
+		if start+count == len(s) {
+			break
+		}
+		words = append(words, s[start:start+count])
+		start += count + len(sep)
+	}
+	return append(words, s[start:])
+}
+
+// escapedFieldLen returns the length of s before the first instance of sep that is not
+// preceded by esc, or len(s) if there is none.  An escaped esc does not escape sep.
+func escapedFieldLen(s, sep string, esc byte) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] == esc {
+			i++
+			continue
+		}
+		if strings.HasPrefix(s[i:], sep) {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// joinLeading joins the leading fields of words with sep so that at most n fields remain.
+func joinLeading(words []string, sep string, n int) []string {
+	if len(words) <= n {
+		return words
+	}
+	i := len(words) - n + 1
+	return append([]string{strings.Join(words[:i], sep)}, words[i:]...)
+}
+
+// sepWidth returns the display width of the output separator, including any padding added to
+// it by SepWidth.
+func (a *Align) sepWidth() int {
+	if w := displayWidth(a.sepOut); w > a.padOpts.SepWidth {
+		return w
+	}
+	return a.padOpts.SepWidth
+}
+
+// writeSep writes the output separator, followed by the padding needed to fill SepWidth.
+func (a *Align) writeSep() {
+	a.writer.WriteString(a.sepOut)
+	if n := a.padOpts.SepWidth - displayWidth(a.sepOut); n > 0 {
+		fillWithPadding(a.padder, n)
+		a.writer.Write(a.padder.Bytes())
+		a.padder.Reset()
+	}
+}
+
+// unalignedLine reports whether line is written unchanged because it is blank, because it
+// does not contain the separator when KeyValue or VerbatimNoSep is set, or because it does not
+// match the pattern given to AlignMatching.
+func (a *Align) unalignedLine(line string) bool {
+	if a.padOpts.SplitOnBlankLines && strings.TrimSpace(line) == "" {
+		return true
+	}
+	if (a.padOpts.KeyValue || a.padOpts.VerbatimNoSep) && !strings.Contains(line, a.sep) {
+		return true
+	}
+	if a.match != nil && !a.match.MatchString(line) {
+		return true
+	}
+	return line == "" && a.padOpts.EmptyLines == EmptyLineVerbatim
+}
+
+// isTail reports whether the field at index c is the tail exempted from padding by FreeTail or
+// Columns, or the value of a KeyValue line.
+func (a *Align) isTail(c int) bool {
+	if a.padOpts.KeyValue {
+		return c == 1
+	}
+	if a.padOpts.Columns > 0 {
+		return !a.padOpts.SplitFromRight && c == a.padOpts.Columns-1
+	}
+	return a.padOpts.FreeTail && a.padOpts.MaxSplits > 0 && !a.padOpts.SplitFromRight && c == a.padOpts.MaxSplits-1
+}
+
+// maxSplits returns the maximum number of fields a line is split into, or 0 if unlimited.
+func (a *Align) maxSplits() int {
+	if a.padOpts.MarkerAlign || a.padOpts.KeyValue {
+		return 2
+	}
+	if a.padOpts.Columns > 0 {
+		return a.padOpts.Columns
+	}
+	n := a.padOpts.MaxSplits
+	if m := a.maxColumns(); m > 0 {
+		if a.padOpts.MaxColumnsError {
+			m++ // one more field reveals that the line has too many
+		}
+		if n <= 0 || m < n {
+			n = m
+		}
+	}
+	return n
+}
+
+// maxColumns returns MaxColumns, or 0 if the number of fields is already fixed by Columns,
+// MarkerAlign or KeyValue.
+func (a *Align) maxColumns() int {
+	if a.padOpts.Columns > 0 || a.padOpts.MarkerAlign || a.padOpts.KeyValue {
+		return 0
+	}
+	return a.padOpts.MaxColumns
+}
+
+// LineRange restricts alignment to the lines numbered start through end (1-based, inclusive).
+// Lines outside of the range are not considered when determining column lengths and are
+// written unchanged.  An end of 0 aligns every line from start to the end of the input.
+func (a *Align) LineRange(start, end int) {
+	a.lineStart = start
+	a.lineEnd = end
+}
+
+// inLineRange reports whether the line numbered n (1-based) should be aligned.
+func (a *Align) inLineRange(n int) bool {
+	if n < a.lineStart {
+		return false
+	}
+	return a.lineEnd <= 0 || n <= a.lineEnd
+}
+
+// AlignMatching aligns only the lines that match re, such as the table rows of a log.  Other
+// lines are written unchanged and do not affect the width of any column.
+func (a *Align) AlignMatching(re *regexp.Regexp) {
+	a.match = re
+}
+
+// SkipRegions sets regions of each line in which the separator is not treated as a boundary.
+// When set, the regions are used instead of the text qualifier.  See CodeRegions.
+func (a *Align) SkipRegions(regions []SkipRegion) {
+	a.skipRegions = regions
+}
+
+// splitSkipping splits s by sep into at most n fields (unlimited if n <= 0),
+// ignoring any instance of sep within one of regions.
+func splitSkipping(s, sep string, n int, regions []SkipRegion) []string {
+	var words = make([]string, 0, strings.Count(s, sep)+1)
+
+	start := 0
+	for i := 0; i < len(s); {
+		if n > 0 && len(words) == n-1 {
+			break
+		}
+		if r, ok := regionAt(s[i:], regions); ok {
+			i += len(r.Open)
+			if r.Close == "" {
+				break
+			}
+			for i < len(s) && !strings.HasPrefix(s[i:], r.Close) {
+				if s[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			i += len(r.Close)
+			continue
+		}
+		if sep != "" && strings.HasPrefix(s[i:], sep) {
+			words = append(words, s[start:i])
+			i += len(sep)
+			start = i
+			continue
+		}
+		i++
+	}
+	if start > len(s) {
+		start = len(s)
+	}
+
+	return append(words, s[start:])
+}
+
+// regionAt returns the region that opens at the beginning of s, if any.
+func regionAt(s string, regions []SkipRegion) (SkipRegion, bool) {
+	for _, r := range regions {
+		if r.Open != "" && strings.HasPrefix(s, r.Open) {
+			return r, true
+		}
+	}
+	return SkipRegion{}, false
+}
+
 // FilterColumns sets which column numbers should be output.
 func (a *Align) FilterColumns(c []int) {
 	a.filter = c
 	a.filterLen = len(c)
 }
 
+// RuleAfter sets the line numbers (1-based) after which a dashed line, as wide as the aligned
+// lines, is written to separate sections of the output.
+func (a *Align) RuleAfter(rows []int) {
+	a.ruleAfter = rows
+}
+
+// FilterColumnsByName sets which columns should be output by the names in the first line of
+// the input (or LineRange), which are resolved once the input has been scanned.  Align returns
+// an error if a name is not found.
+func (a *Align) FilterColumnsByName(names []string) {
+	a.filterNames = names
+}
+
+// OverrideJustificationByName overrides the Justification of the columns with the given names
+// in the first line of the input (or LineRange), in addition to any ColumnOverride.  The names
+// are resolved once the input has been scanned, and Align returns an error if one is not found.
+func (a *Align) OverrideJustificationByName(overrides map[string]Justification) {
+	a.overrideNames = overrides
+}
+
+// resolveNames converts the column names given to FilterColumnsByName and
+// OverrideJustificationByName to column numbers using the fields of the first line.
+func (a *Align) resolveNames() error {
+	if a.filterNames == nil && a.overrideNames == nil {
+		return nil
+	}
+	columns := make(map[string]int, len(a.firstFields))
+	for c, name := range a.firstFields {
+		name = strings.TrimSpace(name)
+		if _, ok := columns[name]; !ok {
+			columns[name] = c + 1
+		}
+	}
+
+	if a.filterNames != nil {
+		filter := make([]int, 0, len(a.filterNames))
+		for _, name := range a.filterNames {
+			c, ok := columns[name]
+			if !ok {
+				return fmt.Errorf("align: no column named %q", name)
+			}
+			filter = append(filter, c)
+		}
+		a.FilterColumns(filter)
+	}
+
+	if a.overrideNames != nil {
+		overrides := make(map[int]Justification, len(a.padOpts.ColumnOverride)+len(a.overrideNames))
+		for k, v := range a.padOpts.ColumnOverride {
+			overrides[k] = v
+		}
+		for name, j := range a.overrideNames {
+			c, ok := columns[name]
+			if !ok {
+				return fmt.Errorf("align: no column named %q", name)
+			}
+			overrides[c] = j
+		}
+		a.padOpts.ColumnOverride = overrides
+	}
+	return nil
+}
+
 func contains(nums []int, num int) bool {
 	for _, v := range nums {
 		if v == num {
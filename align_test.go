@@ -1,9 +1,15 @@
 package align
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/json"
 	"io"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -403,201 +409,3433 @@ var genFieldLenCases = []struct {
 		"'",
 		7,
 	},
+	{
+		"\" | x | \" | y",
+		" | ",
+		"\"",
+		9,
+	},
+	{
+		"\"a | b\" | c",
+		" | ",
+		"\"",
+		7,
+	},
+	{
+		"\"a | b | \" | c",
+		" | ",
+		"\"",
+		10,
+	},
+	{
+		"\",\",x",
+		",",
+		"\"",
+		3,
+	},
+}
+
+var emptyQualifiedFieldCases = []struct {
+	input    string
+	expected []string
+	counts   map[int]int
+}{
+	{
+		"\"\",x",
+		[]string{"\"\"", "x"},
+		map[int]int{0: 2, 1: 1},
+	},
+	{
+		"x,\"\"",
+		[]string{"x", "\"\""},
+		map[int]int{0: 1, 1: 2},
+	},
+	{
+		"\"\",\"\"",
+		[]string{"\"\"", "\"\""},
+		map[int]int{0: 2, 1: 2},
+	},
+	{
+		"\"\"",
+		[]string{"\"\""},
+		map[int]int{0: 2},
+	},
+}
+
+var skipRegionCases = []struct {
+	input    string
+	sep      string
+	expected []string
+}{
+	{
+		"x = 1",
+		"=",
+		[]string{"x ", " 1"},
+	},
+	{
+		"s = \"a=b\" // c=d",
+		"=",
+		[]string{"s ", " \"a=b\" // c=d"},
+	},
+	{
+		"// x = 1",
+		"=",
+		[]string{"// x = 1"},
+	},
+	{
+		"q = \"say \\\"a=b\\\"\" = 2",
+		"=",
+		[]string{"q ", " \"say \\\"a=b\\\"\" ", " 2"},
+	},
+	{
+		"r = `x=y`",
+		"=",
+		[]string{"r ", " `x=y`"},
+	},
+	{
+		"a := b",
+		":=",
+		[]string{"a ", " b"},
+	},
+	{
+		"u = \"unterminated=",
+		"=",
+		[]string{"u ", " \"unterminated="},
+	},
+}
+
+var maxSplitsCases = []struct {
+	input    string
+	sep      string
+	qu       TextQualifier
+	regions  []SkipRegion
+	n        int
+	expected []string
+}{
+	{
+		"key = value = default",
+		"=",
+		TextQualifier{},
+		nil,
+		2,
+		[]string{"key ", " value = default"},
+	},
+	{
+		"a,b,c,d",
+		comma,
+		TextQualifier{},
+		nil,
+		0,
+		[]string{"a", "b", "c", "d"},
+	},
+	{
+		"a,b,c,d",
+		comma,
+		TextQualifier{},
+		nil,
+		3,
+		[]string{"a", "b", "c,d"},
+	},
+	{
+		"\"a,b\",c,d",
+		comma,
+		TextQualifier{On: true, Qualifier: "\""},
+		nil,
+		2,
+		[]string{"\"a,b\"", "c,d"},
+	},
+	{
+		"x = \"=\" = y = z",
+		"=",
+		TextQualifier{},
+		CodeRegions,
+		3,
+		[]string{"x ", " \"=\" ", " y = z"},
+	},
+	{
+		"a,b",
+		comma,
+		TextQualifier{On: true, Qualifier: "\""},
+		nil,
+		1,
+		[]string{"a,b"},
+	},
+}
+
+var groupThousandsCases = []struct {
+	input    string
+	sep      string
+	expected string
+}{
+	{"1234567", ",", "1,234,567"},
+	{"123", ",", "123"},
+	{"1234", ",", "1,234"},
+	{"-1234567.891", ",", "-1,234,567.891"},
+	{"+123456", ",", "+123,456"},
+	{" 98765 ", ",", " 98,765 "},
+	{"1234567", " ", "1 234 567"},
+	{"12a456", ",", "12a456"},
+	{"1.2.3", ",", "1.2.3"},
+	{"-", ",", "-"},
+	{".5", ",", ".5"},
+}
+
+var splitFromRightCases = []struct {
+	input    string
+	sep      string
+	qu       TextQualifier
+	n        int
+	expected []string
+}{
+	{
+		"path/to=file = value",
+		"=",
+		TextQualifier{},
+		0,
+		[]string{"path/to=file ", " value"},
+	},
+	{
+		"a,b,c,d",
+		comma,
+		TextQualifier{},
+		3,
+		[]string{"a,b", "c", "d"},
+	},
+	{
+		"a,b",
+		comma,
+		TextQualifier{},
+		3,
+		[]string{"a", "b"},
+	},
+	{
+		"a,b,\"c,d\"",
+		comma,
+		TextQualifier{On: true, Qualifier: "\""},
+		0,
+		[]string{"a,b", "\"c,d\""},
+	},
+	{
+		"novalue",
+		comma,
+		TextQualifier{},
+		0,
+		[]string{"novalue"},
+	},
+}
+
+var quoteFieldCases = []struct {
+	input    string
+	sep      string
+	qual     string
+	expected string
+}{
+	{"plain", ",", "\"", "plain"},
+	{"a,b", ",", "\"", "\"a,b\""},
+	{"5\" disk", ",", "\"", "\"5\"\" disk\""},
+	{"\"a,b\"", ",", "\"", "\"a,b\""},
+	{"a|b", "|", "'", "'a|b'"},
+	{"a,b", "", "\"", "a,b"},
+	{"a,b", ",", "", "a,b"},
+}
+
+var truncateCases = []struct {
+	input    string
+	width    int
+	anchor   TruncateAnchor
+	expected string
+}{
+	{"short", 10, TruncateEnd, "short"},
+	{"abcdefghij", 6, TruncateEnd, "abcde…"},
+	{"/home/user/very/long/path", 16, TruncateStart, "…/very/long/path"},
+	{"prefix-middle-suffix", 13, TruncateMiddle, "prefix…suffix"},
+	{"世界世界世界", 6, TruncateEnd, "世界…"},
+	{"世界世界世界", 6, TruncateStart, "…世界"},
+	{"世界世界世界", 6, TruncateMiddle, "世…界"},
+	{"abc", 1, TruncateEnd, "…"},
+	{"abc", 0, TruncateEnd, "abc"},
+}
+
+var multiCharSepQualCases = []struct {
+	input    string
+	expected []string
+}{
+	{
+		"\"a | b\" | c | \"d | e | f\"",
+		[]string{"\"a | b\"", "c", "\"d | e | f\""},
+	},
+	{
+		"\" | lead\" | x",
+		[]string{"\" | lead\"", "x"},
+	},
+	{
+		"x | \"trail | \"",
+		[]string{"x", "\"trail | \""},
+	},
+	{
+		"plain | \" | \" | end",
+		[]string{"plain", "\" | \"", "end"},
+	},
+}
+
+var rowNumberCases = []struct {
+	input    string
+	label    string
+	expected string
+}{
+	{
+		"a,b\nc,d",
+		"",
+		"1 , a , b \n2 , c , d \n",
+	},
+	{
+		"one,two\n1,2\n3,4\n5,6\n7,8\n9,10\n11,12\n13,14\n15,16\n17,18",
+		"",
+		" 1 , one , two \n 2 , 1   , 2   \n 3 , 3   , 4   \n 4 , 5   , 6   \n 5 , 7   , 8   \n 6 , 9   , 10  \n 7 , 11  , 12  \n 8 , 13  , 14  \n 9 , 15  , 16  \n10 , 17  , 18  \n",
+	},
+	{
+		"name,age\nbob,42",
+		"#",
+		"# , name , age \n1 , bob  , 42  \n",
+	},
+	{
+		"name,age\nbob,42",
+		"Row",
+		"Row , name , age \n  1 , bob  , 42  \n",
+	},
+}
+
+var asymmetricPadCases = []struct {
+	po       PaddingOpts
+	expected string
+}{
+	{
+		PaddingOpts{Justification: JustifyLeft, PadLeft: 0, PadRight: 1},
+		"a  , bb, c \nddd, e , ff\n",
+	},
+	{
+		PaddingOpts{Justification: JustifyLeft, PadLeft: 2, PadRight: 0},
+		"a    ,bb  ,c   \nddd  ,e   ,ff  \n",
+	},
+	{
+		PaddingOpts{Justification: JustifyLeft, Pad: 1},
+		"a   , bb , c  \nddd , e  , ff \n",
+	},
+	{
+		PaddingOpts{Justification: JustifyRight, Pad: 3, PadLeft: -1, PadRight: 1},
+		"  a, bb,  c\nddd,  e, ff\n",
+	},
+}
+
+var reAlignCases = []struct {
+	input string
+	sep   string
+	qu    TextQualifier
+	po    PaddingOpts
+}{
+	{
+		"a,bb,ccc\ndddd,e,f",
+		comma,
+		TextQualifier{},
+		PaddingOpts{Justification: JustifyLeft, Pad: 1, ReAlign: true},
+	},
+	{
+		"a,bb,ccc\ndddd,e,f",
+		comma,
+		TextQualifier{},
+		PaddingOpts{Justification: JustifyCenter, Pad: 2, ReAlign: true},
+	},
+	{
+		"1||22||333\n4444||5||6",
+		"||",
+		TextQualifier{},
+		PaddingOpts{Justification: JustifyRight, Pad: 1, ReAlign: true},
+	},
+	{
+		"name,\"city, state\"\nbob,\"x, y\"",
+		comma,
+		TextQualifier{On: true, Qualifier: "\""},
+		PaddingOpts{Justification: JustifyRight, Pad: 1, ReAlign: true},
+	},
+}
+
+var lineRangeCases = []struct {
+	start    int
+	end      int
+	expected string
+}{
+	{
+		2,
+		3,
+		"type T struct {\nA    , int    \nLong , string \n}\n",
+	},
+	{
+		3,
+		0,
+		"type T struct {\nA,int\nLong , string \n}    \n",
+	},
+	{
+		0,
+		0,
+		"type T struct { \nA               , int    \nLong            , string \n}               \n",
+	},
+}
+
+var outputSepWidthCases = []struct {
+	sepOut   string
+	pad      int
+	filter   []int
+	expected string
+}{
+	{
+		" | ",
+		1,
+		nil,
+		"a     |  bb  |  c \ndddd  |  e   |  f \nx    \n",
+	},
+	{
+		" | ",
+		0,
+		nil,
+		"a    | bb | c\ndddd | e  | f\nx   \n",
+	},
+	{
+		"",
+		0,
+		nil,
+		"a   bbc\ndddde f\nx   \n",
+	},
+	{
+		"=>",
+		1,
+		[]int{1, 3},
+		"a    => c \ndddd => f \nx    \n",
+	},
+	{
+		"=>",
+		1,
+		[]int{1, 4},
+		"a    \ndddd \nx    \n",
+	},
+}
+
+// TestUpdatePadding
+func TestUpdatePadding(t *testing.T) {
+	for _, tt := range updatePaddingCases {
+		a := &Align{}
+		a.UpdatePadding(PaddingOpts{Justification: tt.input})
+
+		if a.padOpts.Justification != tt.expected {
+			t.Fatalf("updatePadding(%v) = %v; want %v", tt.input, a.padOpts.Justification, tt.expected)
+		}
+	}
+}
+
+// TestColumnSize
+func TestColumnSize(t *testing.T) {
+	for _, tt := range columnSizeCases {
+		a := &Align{columnCounts: tt.counts}
+
+		got := a.columnSize(tt.cNum)
+		if got != tt.expected {
+			t.Fatalf("columnSize(%v) = %v; want %v", tt.cNum, got, tt.expected)
+		}
+	}
+}
+
+// TestOutputSep
+func TestOutputSep(t *testing.T) {
+	for _, tt := range ouputSepCases {
+		a := &Align{}
+		a.OutputSep(tt.input)
+
+		if a.sepOut != tt.expected {
+			t.Fatalf("outputSep(%v) = %v; want %v", tt.input, a.sepOut, tt.expected)
+		}
+	}
+}
+
+// TestColumnFilter
+func TestColumnFilter(t *testing.T) {
+	for _, tt := range exportCases {
+		a := NewAlign(tt.input, tt.output, comma, TextQualifier{})
+		a.UpdatePadding(PaddingOpts{ColumnOverride: tt.overrides, Pad: tt.pad})
+		a.FilterColumns(tt.outColumns)
+
+		a.Align()
+	}
+}
+
+// TestRowNumbers
+func TestRowNumbers(t *testing.T) {
+	for _, tt := range rowNumberCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader(tt.input), out, comma, TextQualifier{})
+		a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1, RowNumbers: true, RowNumberLabel: tt.label})
+		a.Align()
+
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() with RowNumbers = %q; want %q", got, tt.expected)
+		}
+	}
+}
+
+// TestRowNumbersLineRange
+func TestRowNumbersLineRange(t *testing.T) {
+	out := &bytes.Buffer{}
+	a := NewAlign(strings.NewReader("title\nname,qty\na,1\n# note\nb,2"), out, comma, TextQualifier{})
+	a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1, RowNumbers: true, RowNumberLabel: "#"})
+	a.LineRange(2, 0)
+	a.AlignMatching(regexp.MustCompile(","))
+	a.Align()
+
+	expected := "title\n# , name , qty \n1 , a    , 1   \n# note\n2 , b    , 2   \n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Align() with RowNumbers and LineRange = %q; want %q", got, expected)
+	}
+}
+
+func TestRowNumbers_Filter(t *testing.T) {
+	out := &bytes.Buffer{}
+	a := NewAlign(strings.NewReader("a,b,c\nd,e,f"), out, comma, TextQualifier{})
+	a.UpdatePadding(PaddingOpts{
+		Justification:  JustifyLeft,
+		ColumnOverride: map[int]Justification{3: JustifyRight},
+		Pad:            1,
+		RowNumbers:     true,
+	})
+	a.FilterColumns([]int{1, 3})
+	a.Align()
+
+	expected := "1 , a , c \n2 , d , f \n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Align() with RowNumbers and FilterColumns = %q; want %q", got, expected)
+	}
+}
+
+// TestAlignBytes
+func TestAlignBytes(t *testing.T) {
+	got, err := AlignBytes([]byte("first,last\nJo,Smith"), comma, TextQualifier{}, PaddingOpts{Justification: JustifyRight, Pad: 1})
+	if err != nil {
+		t.Fatalf("AlignBytes() returned error: %v", err)
+	}
+
+	expected := "first ,  last \n   Jo , Smith \n"
+	if string(got) != expected {
+		t.Fatalf("AlignBytes() = %q; want %q", got, expected)
+	}
+}
+
+func TestAlignBytes_Failure(t *testing.T) {
+	input := bytes.Repeat([]byte("x"), bufio.MaxScanTokenSize+1)
+	if _, err := AlignBytes(input, comma, TextQualifier{}, PaddingOpts{}); err == nil {
+		t.Fatalf("AlignBytes() with a line exceeding the max token size should return an error")
+	}
+}
+
+// TestAsymmetricPad
+func TestAsymmetricPad(t *testing.T) {
+	for _, tt := range asymmetricPadCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader("a,bb,c\nddd,e,ff"), out, comma, TextQualifier{})
+		a.UpdatePadding(tt.po)
+		a.Align()
+
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() with %+v = %q; want %q", tt.po, got, tt.expected)
+		}
+	}
+}
+
+// TestReAlign
+func TestReAlign(t *testing.T) {
+	for _, tt := range reAlignCases {
+		first, err := AlignBytes([]byte(tt.input), tt.sep, tt.qu, tt.po)
+		if err != nil {
+			t.Fatalf("AlignBytes(%q) returned error: %v", tt.input, err)
+		}
+		second, err := AlignBytes(first, tt.sep, tt.qu, tt.po)
+		if err != nil {
+			t.Fatalf("AlignBytes(%q) returned error: %v", first, err)
+		}
+
+		if !bytes.Equal(first, second) {
+			t.Fatalf("re-aligning %q = %q; want %q", tt.input, second, first)
+		}
+	}
+}
+
+// TestLineRange
+func TestLineRange(t *testing.T) {
+	for _, tt := range lineRangeCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader("type T struct {\nA,int\nLong,string\n}"), out, comma, TextQualifier{})
+		a.LineRange(tt.start, tt.end)
+		a.Align()
+
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("LineRange(%v, %v) = %q; want %q", tt.start, tt.end, got, tt.expected)
+		}
+	}
+}
+
+// TestMarkerAlign
+func TestMarkerAlign(t *testing.T) {
+	input := "  x := 1 // one\n  longer := \"//\"  // two, three\n  // standalone\n  noComment()"
+	out := &bytes.Buffer{}
+	a := NewAlign(strings.NewReader(input), out, "//", TextQualifier{})
+	a.SkipRegions([]SkipRegion{{Open: "\"", Close: "\""}})
+	a.UpdatePadding(PaddingOpts{Pad: 1, MarkerAlign: true})
+	a.Align()
+
+	expected := "  x := 1         // one\n  longer := \"//\" // two, three\n  // standalone\n  noComment()\n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Align() with MarkerAlign = %q; want %q", got, expected)
+	}
+}
+
+var markerAlignQualifierCases = []struct {
+	input    string
+	expected string
+}{
+	{"a // x\n\nbb // y", "a  // x\n\nbb // y\n"},
+	{"\nbb // y\na // x", "\nbb // y\na  // x\n"},
+}
+
+// TestMarkerAlignQualifier
+func TestMarkerAlignQualifier(t *testing.T) {
+	for _, tt := range markerAlignQualifierCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader(tt.input), out, "//", TextQualifier{On: true, Qualifier: `"`})
+		a.UpdatePadding(PaddingOpts{Pad: 1, MarkerAlign: true})
+		a.Align()
+
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() of %q with MarkerAlign and a text qualifier = %q; want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+// TestSeparatorColumn
+func TestSeparatorColumn(t *testing.T) {
+	input := "name=align\n  port   =8080\n# no separator\n世界=wide = sign"
+	out := &bytes.Buffer{}
+	a := NewAlign(strings.NewReader(input), out, "=", TextQualifier{})
+	a.UpdatePadding(PaddingOpts{Pad: 1, SeparatorColumn: true})
+	a.Align()
+
+	expected := "name   =align\n  port =8080\n# no separator\n世界   =wide = sign\n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Align() with SeparatorColumn = %q; want %q", got, expected)
+	}
+
+	// aligning the output again leaves it unchanged
+	again := &bytes.Buffer{}
+	a = NewAlign(strings.NewReader(expected), again, "=", TextQualifier{})
+	a.UpdatePadding(PaddingOpts{Pad: 1, SeparatorColumn: true})
+	a.Align()
+	if got := again.String(); got != expected {
+		t.Fatalf("Align() with SeparatorColumn of aligned input = %q; want %q", got, expected)
+	}
+}
+
+var validateFilterCases = []struct {
+	filter    []int
+	shouldErr bool
+}{
+	{nil, false},
+	{[]int{1, 3}, false},
+	{[]int{4}, true},
+	{[]int{0, 2}, true},
+}
+
+// TestValidateFilter
+func TestValidateFilter(t *testing.T) {
+	for _, tt := range validateFilterCases {
+		a := NewAlign(strings.NewReader("a,b,c\nd,e"), &bytes.Buffer{}, comma, TextQualifier{})
+		a.FilterColumns(tt.filter)
+		a.Align()
+
+		if err := a.ValidateFilter(); (err != nil) != tt.shouldErr {
+			t.Fatalf("ValidateFilter() with %v returned %v; want an error: %v", tt.filter, err, tt.shouldErr)
+		}
+	}
+}
+
+// TestColumnsByName
+func TestColumnsByName(t *testing.T) {
+	input := "id, name ,city\n1,Jo,Oslo\n22,Alexander,Rome"
+	out := &bytes.Buffer{}
+	a := NewAlign(strings.NewReader(input), out, comma, TextQualifier{})
+	a.FilterColumnsByName([]string{"id", "city"})
+	a.OverrideJustificationByName(map[string]Justification{"id": JustifyRight})
+	if err := a.Align(); err != nil {
+		t.Fatalf("Align() with names returned error: %v", err)
+	}
+
+	expected := "id , city \n 1 , Oslo \n22 , Rome \n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Align() with FilterColumnsByName = %q; want %q", got, expected)
+	}
+
+	a = NewAlign(strings.NewReader(input), &bytes.Buffer{}, comma, TextQualifier{})
+	a.FilterColumnsByName([]string{"zip"})
+	if err := a.Align(); err == nil {
+		t.Fatalf("Align() with FilterColumnsByName of a missing column should return an error")
+	}
+
+	a = NewAlign(strings.NewReader(input), &bytes.Buffer{}, comma, TextQualifier{})
+	a.OverrideJustificationByName(map[string]Justification{"zip": JustifyRight})
+	if err := a.Align(); err == nil {
+		t.Fatalf("Align() with OverrideJustificationByName of a missing column should return an error")
+	}
+}
+
+// countingWriter counts the calls to Write.
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+var flushEveryCases = []struct {
+	po     PaddingOpts
+	writes int
+}{
+	{PaddingOpts{Pad: 1}, 1},
+	{PaddingOpts{Pad: 1, FlushEvery: 1}, 5},
+	{PaddingOpts{Pad: 1, FlushEvery: 2}, 3},
+	{PaddingOpts{Pad: 1, FlushEvery: 2, Compact: true}, 3},
+}
+
+// TestFlushEvery
+func TestFlushEvery(t *testing.T) {
+	for _, tt := range flushEveryCases {
+		out := &countingWriter{}
+		a := NewAlign(strings.NewReader("a,b\nc,d\ne,f\ng,h\ni,j"), out, comma, TextQualifier{})
+		a.UpdatePadding(tt.po)
+		a.Align()
+
+		if out.writes != tt.writes {
+			t.Fatalf("Align() with FlushEvery %d wrote %d times; want %d", tt.po.FlushEvery, out.writes, tt.writes)
+		}
+	}
+}
+
+// TestCompact
+func TestCompact(t *testing.T) {
+	input := "name      ,  city   , zip\n  Jo ,Oslo,0150\nAlexander,   Rome"
+	for _, po := range []PaddingOpts{{Pad: 1, Compact: true}, {Pad: 1, Compact: true, Indent: "> "}} {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader(input), out, comma, TextQualifier{})
+		a.UpdatePadding(po)
+		a.OutputSep("|")
+		a.Align()
+
+		expected := po.Indent + "name | city | zip\n" + po.Indent + "Jo | Oslo | 0150\n" + po.Indent + "Alexander | Rome\n"
+		if got := out.String(); got != expected {
+			t.Fatalf("Align() with Compact = %q; want %q", got, expected)
+		}
+
+		// reading the output yields the same result
+		a = NewAlign(strings.NewReader(input), &bytes.Buffer{}, comma, TextQualifier{})
+		a.UpdatePadding(po)
+		a.OutputSep("|")
+		got, _ := io.ReadAll(a.Reader())
+		if string(got) != expected {
+			t.Fatalf("ReadAll(Reader()) with Compact = %q; want %q", got, expected)
+		}
+	}
+}
+
+var compactLinesCases = []struct {
+	input    string
+	po       PaddingOpts
+	setup    func(a *Align)
+	expected string
+	err      bool
+}{
+	{"a , b\nc , d\ne , f", PaddingOpts{}, func(a *Align) { a.LineRange(2, 2) }, "a , b\nc,d\ne , f\n", false},
+	{"a , b\n\nc , d", PaddingOpts{EmptyLines: EmptyLineSkip}, nil, "a,b\nc,d\n", false},
+	{"a , b\n\nc , d", PaddingOpts{EmptyLines: EmptyLineError}, nil, "", true},
+	{"#align: sep=|\na | b\nc | d", PaddingOpts{Directive: true}, nil, "a|b\nc|d\n", false},
+	{"\xef\xbb\xbfa , b", PaddingOpts{}, nil, "a,b\n", false},
+	{"\xff\xfea , b", PaddingOpts{}, nil, "", true},
+	{"a , b\n# c , d", PaddingOpts{}, func(a *Align) { a.AlignMatching(regexp.MustCompile(`^\w`)) }, "a,b\n# c , d\n", false},
+	{"a , b\nnote", PaddingOpts{VerbatimNoSep: true}, nil, "a,b\nnote\n", false},
+	{"id , name , qty\n1 , x , 2", PaddingOpts{}, func(a *Align) { a.FilterColumnsByName([]string{"id", "qty"}) }, "id,qty\n1,2\n", false},
+	{"id , name\n1 , x", PaddingOpts{}, func(a *Align) { a.FilterColumnsByName([]string{"missing"}) }, "", true},
+	{"a,b", PaddingOpts{CSV: true}, nil, "", true},
+}
+
+// TestCompactLines
+func TestCompactLines(t *testing.T) {
+	for _, tt := range compactLinesCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader(tt.input), out, comma, TextQualifier{})
+		tt.po.Compact = true
+		a.UpdatePadding(tt.po)
+		if tt.setup != nil {
+			tt.setup(a)
+		}
+		err := a.Align()
+
+		if (err != nil) != tt.err {
+			t.Fatalf("Align() of %q with Compact returned %v", tt.input, err)
+		}
+		if !tt.err && out.String() != tt.expected {
+			t.Fatalf("Align() of %q with Compact = %q; want %q", tt.input, out.String(), tt.expected)
+		}
+	}
+
+	out := &bytes.Buffer{}
+	a := NewAlign(strings.NewReader("#align: sep=|\na | b\n\nc | d"), out, comma, TextQualifier{})
+	a.UpdatePadding(PaddingOpts{Directive: true, EmptyLines: EmptyLineSkip})
+	if err := a.Compactify(); err != nil || out.String() != "a|b\nc|d\n" {
+		t.Fatalf("Compactify() = %q, %v; want %q", out.String(), err, "a|b\nc|d\n")
+	}
+}
+
+// TestNormalize
+func TestNormalize(t *testing.T) {
+	out := &bytes.Buffer{}
+	a := NewAlign(strings.NewReader("id\tname\n1\tAlexander\n22\t\n"), out, "\t", TextQualifier{})
+	a.UpdatePadding(PaddingOpts{Pad: 1, Normalize: true})
+	a.Align()
+
+	expected := "id\tname\n1\tAlexander\n22\t\n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Align() with Normalize = %q; want %q", got, expected)
+	}
+
+	widths := a.ColumnWidths()
+	if widths[1] != 2 || widths[2] != 9 || len(widths) != 2 {
+		t.Fatalf("ColumnWidths() = %v; want map[1:2 2:9]", widths)
+	}
+}
+
+var csvCases = []struct {
+	quote    bool
+	expected string
+}{
+	{false, "name      , note      \nSmith, Jo , 5\" disk   \nLi        , two\nlines  \n"},
+	{true, "name        , note        \n\"Smith, Jo\" , \"5\"\" disk\"  \nLi          , \"two\nlines\"  \n"},
+}
+
+// TestCSV
+func TestCSV(t *testing.T) {
+	input := "name,note\n\"Smith, Jo\",\"5\"\" disk\"\nLi,\"two\nlines\"\n"
+	for _, tt := range csvCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader(input), out, comma, TextQualifier{})
+		a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1, CSV: true, QuoteOnOutput: tt.quote})
+		if err := a.Align(); err != nil {
+			t.Fatalf("Align() with CSV returned error: %v", err)
+		}
+
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() with CSV and QuoteOnOutput %v = %q; want %q", tt.quote, got, tt.expected)
+		}
+	}
+
+	for _, sep := range []string{"", "::"} {
+		a := NewAlign(strings.NewReader(input), &bytes.Buffer{}, sep, TextQualifier{})
+		a.UpdatePadding(PaddingOpts{CSV: true})
+		if err := a.Align(); err == nil {
+			t.Fatalf("Align() with CSV and separator %q should return an error", sep)
+		}
+	}
+
+	a := NewAlign(strings.NewReader("a,\"b\nc"), &bytes.Buffer{}, comma, TextQualifier{})
+	a.UpdatePadding(PaddingOpts{CSV: true})
+	if err := a.Align(); err == nil {
+		t.Fatalf("Align() with CSV of an unterminated quote should return an error")
+	}
+}
+
+// TestSetSplitFunc
+func TestSetSplitFunc(t *testing.T) {
+	out := &bytes.Buffer{}
+	a := NewAlign(strings.NewReader("ls  -la /tmp\ngit   commit -m\n\nx"), out, "|", TextQualifier{})
+	a.SetSplitFunc(strings.Fields)
+	a.OutputSep(" ")
+	a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 0})
+	a.Align()
+
+	expected := "ls  -la    /tmp\ngit commit -m  \n   \nx  \n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Align() with SetSplitFunc = %q; want %q", got, expected)
+	}
+	if got := a.Split("a b"); len(got) != 2 {
+		t.Fatalf("Split(%q) with SetSplitFunc = %q; want 2 fields", "a b", got)
+	}
+}
+
+// TestSetCellTransform
+func TestSetCellTransform(t *testing.T) {
+	out := &bytes.Buffer{}
+	a := NewAlign(strings.NewReader("user,password\nalexander,hunter2\njo,correct horse battery"), out, comma, TextQualifier{})
+	a.SetCellTransform(func(row, col int, value string) string {
+		if row > 1 && col == 2 {
+			return "***"
+		}
+		return strings.ToUpper(value)
+	})
+	a.Align()
+
+	expected := "USER      , PASSWORD \nALEXANDER , ***      \nJO        , ***      \n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Align() with SetCellTransform = %q; want %q", got, expected)
+	}
+}
+
+// latin1Reader decodes ISO 8859-1 input to UTF-8.
+func latin1Reader(r io.Reader) io.Reader {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		panic(err)
+	}
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return strings.NewReader(string(runes))
+}
+
+// TestDecodeInput
+func TestDecodeInput(t *testing.T) {
+	out := &bytes.Buffer{}
+	a := NewAlign(strings.NewReader("city,zip\nM\xe4rsta,9038\nOslo,0150"), out, comma, TextQualifier{})
+	a.DecodeInput(latin1Reader)
+	a.Align()
+
+	expected := "city    , zip  \nMärsta  , 9038 \nOslo    , 0150 \n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Align() with DecodeInput = %q; want %q", got, expected)
+	}
+}
+
+var bomCases = []struct {
+	input    string
+	preserve bool
+	expected string
+	err      error
+}{
+	{"\xef\xbb\xbfid,name\n10,Jo", false, "id , name \n10 , Jo   \n", nil},
+	{"\xef\xbb\xbfid,name\n10,Jo", true, "\xef\xbb\xbfid , name \n10 , Jo   \n", nil},
+	{"id,name\n10,Jo", true, "id , name \n10 , Jo   \n", nil},
+	{"\xff\xfei\x00d\x00", false, "", ErrUTF16},
+	{"\xfe\xff\x00i\x00d", false, "", ErrUTF16},
+}
+
+// TestBOM
+func TestBOM(t *testing.T) {
+	for _, tt := range bomCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader(tt.input), out, comma, TextQualifier{})
+		a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1, PreserveBOM: tt.preserve})
+
+		if err := a.Align(); err != tt.err {
+			t.Fatalf("Align() of %q returned error %v; want %v", tt.input, err, tt.err)
+		}
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() of %q with PreserveBOM %v = %q; want %q", tt.input, tt.preserve, got, tt.expected)
+		}
+	}
+}
+
+var sepWidthCases = []struct {
+	outSep   string
+	expected string
+}{
+	{"=>", "key=>   value\nk  =>   v    \n"},
+	{" ==> ", "key ==> value\nk   ==> v    \n"},
+	{" -> ", "key ->  value\nk   ->  v    \n"},
+}
+
+var totalWidthCases = []struct {
+	po     PaddingOpts
+	filter []int
+}{
+	{PaddingOpts{Justification: JustifyLeft, Pad: 1}, nil},
+	{PaddingOpts{Justification: JustifyRight, Pad: 2}, nil},
+	{PaddingOpts{Justification: JustifyLeft, PadLeft: 3, PadRight: 1, TrimTrailingSpace: true}, nil},
+	{PaddingOpts{Justification: JustifyLeft, Pad: 1, RowNumbers: true, HashColumn: true, Indent: "  "}, nil},
+	{PaddingOpts{Justification: JustifyLeft, Pad: 1, SepWidth: 4}, []int{1, 3}},
+	{PaddingOpts{Justification: JustifyLeft, Pad: 1, OuterPad: 3, HashColumn: true}, nil},
+}
+
+var outerPadCases = []struct {
+	po       PaddingOpts
+	expected string
+}{
+	{PaddingOpts{Justification: JustifyLeft, Pad: 1, OuterPad: 2}, "  a   , bb  \n  ccc , d   \n"},
+	{PaddingOpts{Justification: JustifyRight, Pad: 0, OuterPad: 1}, "   a,bb \n ccc, d \n"},
+	{PaddingOpts{Justification: JustifyLeft, Pad: 1, OuterPad: 2, TrimTrailingSpace: true}, "  a   , bb\n  ccc , d\n"},
+	{PaddingOpts{Justification: JustifyLeft, Pad: 1, OuterPad: 1, RowNumbers: true}, " 1 , a   , bb \n 2 , ccc , d  \n"},
+}
+
+// TestOuterPad
+func TestOuterPad(t *testing.T) {
+	for _, tt := range outerPadCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader("a,bb\nccc,d"), out, comma, TextQualifier{})
+		a.UpdatePadding(tt.po)
+		a.Align()
+
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() with OuterPad %d = %q; want %q", tt.po.OuterPad, got, tt.expected)
+		}
+		if w, expected := a.TotalWidth(), len(strings.SplitN(tt.expected, "\n", 2)[0]); w != expected {
+			t.Fatalf("TotalWidth() with OuterPad %d = %d; want %d", tt.po.OuterPad, w, expected)
+		}
+	}
+}
+
+// TestTotalWidth
+func TestTotalWidth(t *testing.T) {
+	for _, tt := range totalWidthCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader("first,last,city\nJo,Smith,Oslo\nAlexander,Li,Rome"), out, comma, TextQualifier{})
+		a.UpdatePadding(tt.po)
+		a.FilterColumns(tt.filter)
+		a.Align()
+
+		var expected int
+		for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+			if len(line) > expected {
+				expected = len(line)
+			}
+		}
+		if got := a.TotalWidth(); got != expected {
+			t.Fatalf("TotalWidth() with %+v = %d; want %d for %q", tt.po, got, expected, out.String())
+		}
+	}
+}
+
+var maxTotalWidthCases = []struct {
+	max      int
+	expected string
+}{
+	{0, "id , description               , owner     \n1  , a rather long description , Alexander \n2  , short                     , Jo        \n"},
+	{35, "id , description       , owner     \n1  , a rather long de… , Alexander \n2  , short             , Jo        \n"},
+	{1, "… , … , … \n1 , … , … \n2 , … , … \n"},
+}
+
+// TestMaxTotalWidth
+func TestMaxTotalWidth(t *testing.T) {
+	for _, tt := range maxTotalWidthCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader("id,description,owner\n1,a rather long description,Alexander\n2,short,Jo"), out, comma, TextQualifier{})
+		a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1, MaxTotalWidth: tt.max})
+		a.Align()
+
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() with MaxTotalWidth %d = %q; want %q", tt.max, got, tt.expected)
+		}
+	}
+}
+
+// TestSepWidth
+func TestSepWidth(t *testing.T) {
+	for _, tt := range sepWidthCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader("key,value\nk,v"), out, comma, TextQualifier{})
+		a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, SepWidth: 5})
+		a.OutputSep(tt.outSep)
+		a.Align()
+
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() with SepWidth 5 and OutputSep(%q) = %q; want %q", tt.outSep, got, tt.expected)
+		}
+	}
+}
+
+var emptyLinePolicyCases = []struct {
+	policy    EmptyLinePolicy
+	expected  string
+	shouldErr bool
+}{
+	{EmptyLineKeep, "a   , b \n    \nccc , d \n", false},
+	{EmptyLineVerbatim, "a   , b \n\nccc , d \n", false},
+	{EmptyLineSkip, "a   , b \nccc , d \n", false},
+	{EmptyLineError, "", true},
+}
+
+// TestEmptyLinePolicy
+func TestEmptyLinePolicy(t *testing.T) {
+	for _, tt := range emptyLinePolicyCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader("a,b\n\nccc,d"), out, comma, TextQualifier{})
+		a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1, EmptyLines: tt.policy})
+		err := a.Align()
+
+		if tt.shouldErr {
+			if err == nil {
+				t.Fatalf("Align() with EmptyLinePolicy %d should return an error", tt.policy)
+			}
+			continue
+		}
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() with EmptyLinePolicy %d = %q; want %q", tt.policy, got, tt.expected)
+		}
+	}
+}
+
+var keyValueCases = []struct {
+	perRecord bool
+	expected  string
+}{
+	{false, "From         : jo@example.com\nSubject      : a: b\n  continued\n\nTo           : li@example.com\nContent-Type : text/plain\n"},
+	{true, "From    : jo@example.com\nSubject : a: b\n  continued\n\nTo           : li@example.com\nContent-Type : text/plain\n"},
+}
+
+// TestKeyValue
+func TestKeyValue(t *testing.T) {
+	input := "From: jo@example.com\nSubject:a: b\n  continued\n\nTo:   li@example.com\nContent-Type : text/plain"
+	for _, tt := range keyValueCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader(input), out, ":", TextQualifier{})
+		a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1, KeyValue: true, SplitOnBlankLines: tt.perRecord})
+		a.Align()
+
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() with KeyValue and SplitOnBlankLines %v = %q; want %q", tt.perRecord, got, tt.expected)
+		}
+	}
+}
+
+// TestFreeTail
+func TestFreeTail(t *testing.T) {
+	input := "2024-01-01 12:00:00,ERROR,disk full, retrying\n2024-01-01 12:00:05,WARN,slow,\n2024-01-01 12:01:00,INFORMATION\n"
+	out := &bytes.Buffer{}
+	a := NewAlign(strings.NewReader(input), out, comma, TextQualifier{})
+	a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1, MaxSplits: 3, FreeTail: true, TrimTrailingSpace: true})
+	a.Align()
+
+	expected := "2024-01-01 12:00:00 , ERROR       , disk full, retrying\n2024-01-01 12:00:05 , WARN        , slow,\n2024-01-01 12:01:00 , INFORMATION\n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Align() with FreeTail = %q; want %q", got, expected)
+	}
+}
+
+// TestSplitOnBlankLines
+func TestSplitOnBlankLines(t *testing.T) {
+	out := &bytes.Buffer{}
+	a := NewAlign(strings.NewReader("a,b\nccc,d\n\n\nlonger field,x\ny,z\n  \nq,r"), out, comma, TextQualifier{})
+	a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1, SplitOnBlankLines: true})
+	a.Align()
+
+	expected := "a   , b \nccc , d \n\n\nlonger field , x \ny            , z \n  \nq , r \n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Align() with SplitOnBlankLines = %q; want %q", got, expected)
+	}
+}
+
+var splitBlockWidthCases = []struct {
+	po       PaddingOpts
+	expected string
+}{
+	{PaddingOpts{Justification: JustifyLeft, Pad: 1, SplitOnBlankLines: true, MaxTotalWidth: 10}, "a   , b \nccc , d \n\nlong… , x \ny     , z \n"},
+	{PaddingOpts{Justification: JustifyLeft, Pad: 1, SplitOnBlankLines: true, FillWidth: 12}, "a   , b     \nccc , d     \n\nlonger field , x \ny            , z \n"},
+	{PaddingOpts{Justification: JustifyLeft, Pad: 1, SplitOnBlankLines: true, FillWidth: 12, FlexColumn: 1}, "a       , b \nccc     , d \n\nlonger… , x \ny       , z \n"},
+	{PaddingOpts{Justification: JustifyLeft, Pad: 1, SplitOnBlankLines: true, MinColWidth: map[int]int{2: 3}}, "a   , b   \nccc , d   \n\nlonger field , x   \ny            , z   \n"},
+}
+
+// TestSplitOnBlankLinesWidths
+func TestSplitOnBlankLinesWidths(t *testing.T) {
+	for i, tt := range splitBlockWidthCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader("a,b\nccc,d\n\nlonger field,x\ny,z"), out, comma, TextQualifier{})
+		a.UpdatePadding(tt.po)
+		a.Align()
+
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() of case %d with SplitOnBlankLines = %q; want %q", i, got, tt.expected)
+		}
+	}
+}
+
+// TestStream
+func TestStream(t *testing.T) {
+	out := &bytes.Buffer{}
+	s := NewStream(out, comma, TextQualifier{})
+
+	io.WriteString(s, "id,name\n1,Jo\n")
+	if out.Len() != 0 {
+		t.Fatalf("Stream wrote %q before the block was complete", out.String())
+	}
+
+	got, err := s.Aligned()
+	if err != nil {
+		t.Fatalf("Aligned() returned error: %v", err)
+	}
+	if expected := "id , name \n1  , Jo   \n"; string(got) != expected {
+		t.Fatalf("Aligned() = %q; want %q", got, expected)
+	}
+
+	// a wider field and a new column realign the earlier lines of the block.
+	io.WriteString(s, "22,Alexander,admin\n\nx,y\nlonger,z")
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Flush() returned error: %v", err)
+	}
+
+	expected := "id , name      \n1  , Jo        \n22 , Alexander , admin \n\nx      , y \nlonger , z \n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Stream output = %q; want %q", got, expected)
+	}
+}
+
+// TestSuppressEmptySep
+func TestSuppressEmptySep(t *testing.T) {
+	out := &bytes.Buffer{}
+	a := NewAlign(strings.NewReader("a,b,c,d\n1,,3,4\n,2,,4"), out, comma, TextQualifier{})
+	a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1, SuppressEmptySep: true})
+	a.Align()
+
+	expected := "a , b , c , d \n1       3 , 4 \n    2       4 \n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Align() with SuppressEmptySep = %q; want %q", got, expected)
+	}
+
+	out = &bytes.Buffer{}
+	a = NewAlign(strings.NewReader("a,b,c,d\n1,,3,4\n,2, ,4"), out, comma, TextQualifier{})
+	a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1, SuppressEmptySep: true, EmptyValue: "-", EmptyBlank: true})
+	a.Align()
+
+	expected = "a , b , c , d \n1 , - , 3 , 4 \n- , 2 , - , 4 \n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Align() with SuppressEmptySep and EmptyValue = %q; want %q", got, expected)
+	}
+}
+
+// TestReader
+func TestReader(t *testing.T) {
+	input := "first,last\nJo,Smith\nAlexander,Li"
+	expected := "first     , last  \nJo        , Smith \nAlexander , Li    \n"
+
+	a := NewAlign(strings.NewReader(input), &bytes.Buffer{}, comma, TextQualifier{})
+	got, err := io.ReadAll(a.Reader())
+	if err != nil {
+		t.Fatalf("ReadAll(Reader()) returned error: %v", err)
+	}
+	if string(got) != expected {
+		t.Fatalf("ReadAll(Reader()) = %q; want %q", got, expected)
+	}
+
+	// small reads must yield the same result
+	a = NewAlign(strings.NewReader(input), &bytes.Buffer{}, comma, TextQualifier{})
+	r := a.Reader()
+	var out bytes.Buffer
+	p := make([]byte, 3)
+	for {
+		n, err := r.Read(p)
+		out.Write(p[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read() returned error: %v", err)
+		}
+	}
+	if out.String() != expected {
+		t.Fatalf("Read() in 3 byte chunks = %q; want %q", out.String(), expected)
+	}
+}
+
+// TestMinColWidth
+func TestMinColWidth(t *testing.T) {
+	out := &bytes.Buffer{}
+	a := NewAlign(strings.NewReader("a,b,c\nd,e,f"), out, comma, TextQualifier{})
+	a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1, MinColWidth: map[int]int{1: 3, 3: 2, 4: 9}})
+	a.Align()
+
+	expected := "a   , b , c  \nd   , e , f  \n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Align() with MinColWidth = %q; want %q", got, expected)
+	}
+}
+
+// TestHeaderWidths
+func TestHeaderWidths(t *testing.T) {
+	out := &bytes.Buffer{}
+	mins := map[int]int{2: 4}
+	a := NewAlign(strings.NewReader("name:6,age,note:x,city:2\nbob,42,hi,Springfield"), out, comma, TextQualifier{})
+	a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1, MinColWidth: mins, HeaderWidths: true})
+	a.Align()
+
+	expected := "name   , age  , note:x , city        \nbob    , 42   , hi     , Springfield \n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Align() with HeaderWidths = %q; want %q", got, expected)
+	}
+	if len(mins) != 1 {
+		t.Fatalf("Align() with HeaderWidths modified the caller's MinColWidth: %v", mins)
+	}
+
+	out = &bytes.Buffer{}
+	a = NewAlign(strings.NewReader("name:6,\"note, long:12\"\nbob,hi"), out, comma, TextQualifier{})
+	a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1, HeaderWidths: true, CSV: true})
+	a.Align()
+
+	expected = "name   , note, long   \nbob    , hi           \n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Align() with HeaderWidths and CSV = %q; want %q", got, expected)
+	}
+}
+
+// TestAlignTwice
+func TestAlignTwice(t *testing.T) {
+	out := &bytes.Buffer{}
+	a := NewAlign(strings.NewReader("a,b\ncc,d"), out, comma, TextQualifier{})
+	if err := a.Align(); err != nil {
+		t.Fatalf("Align() returned error: %v", err)
+	}
+	first := out.String()
+
+	if err := a.Align(); err != ErrAligned {
+		t.Fatalf("second Align() = %v; want %v", err, ErrAligned)
+	}
+	if out.String() != first {
+		t.Fatalf("second Align() wrote %q; want no output", strings.TrimPrefix(out.String(), first))
+	}
+	if _, err := a.Reader().Read(make([]byte, 8)); err != ErrAligned {
+		t.Fatalf("Reader().Read() after Align() = %v; want %v", err, ErrAligned)
+	}
+}
+
+// TestIndent
+func TestIndent(t *testing.T) {
+	out := &bytes.Buffer{}
+	a := NewAlign(strings.NewReader("- list item\na,bb\nccc,d"), out, comma, TextQualifier{})
+	a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1, Indent: "    ", RowNumbers: true})
+	a.LineRange(2, 0)
+	a.Align()
+
+	expected := "- list item\n    1 , a   , bb \n    2 , ccc , d  \n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Align() with Indent = %q; want %q", got, expected)
+	}
+}
+
+// TestHashColumn
+func TestHashColumn(t *testing.T) {
+	out := &bytes.Buffer{}
+	a := NewAlign(strings.NewReader("a,bb\nccc,d"), out, comma, TextQualifier{})
+	a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1, HashColumn: true})
+	a.Align()
+
+	expected := "a   , bb , 67fef9af \nccc , d  , 085c19fb \n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Align() with HashColumn = %q; want %q", got, expected)
+	}
+
+	out.Reset()
+	a = NewAlign(strings.NewReader("a,bb\nccc,d"), out, comma, TextQualifier{})
+	a.UpdatePadding(PaddingOpts{
+		Justification: JustifyLeft,
+		Pad:           1,
+		HashColumn:    true,
+		HashFunc:      func(line string) string { return strings.Repeat("#", len(line)) },
+	})
+	a.FilterColumns([]int{1})
+	a.Align()
+
+	expected = "a   , ####  \nccc , ##### \n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Align() with HashFunc = %q; want %q", got, expected)
+	}
+}
+
+// TestTabStop
+func TestTabStop(t *testing.T) {
+	out := &bytes.Buffer{}
+	a := NewAlign(strings.NewReader("a,bbbb,ccccc\ndd,e,f"), out, comma, TextQualifier{})
+	a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 0, TabStop: 4})
+	a.Align()
+
+	expected := "a   ,bbbb    ,ccccc   \ndd  ,e       ,f       \n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Align() with TabStop = %q; want %q", got, expected)
+	}
+}
+
+// TestElasticTabstops
+func TestElasticTabstops(t *testing.T) {
+	input := "a,b,c\nlonger,b\nx\nshort,verylongvalue,z\ny,q"
+	out := &bytes.Buffer{}
+	a := NewAlign(strings.NewReader(input), out, comma, TextQualifier{})
+	a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1, ElasticTabstops: true})
+	a.Align()
+
+	expected := "a      , b , c \nlonger , b \nx \nshort , verylongvalue , z \ny     , q \n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Align() with ElasticTabstops = %q; want %q", got, expected)
+	}
+}
+
+var trimTrailingSpaceCases = []struct {
+	po       PaddingOpts
+	expected string
+}{
+	{
+		PaddingOpts{Justification: JustifyLeft, Pad: 1, TrimTrailingSpace: true},
+		"a   , bb\nccc , d\n",
+	},
+	{
+		PaddingOpts{Justification: JustifyLeft, Pad: 1, TrimTrailingSpace: true, HashColumn: true, HashFunc: func(string) string { return "h" }},
+		"a   , bb , h\nccc , d  , h\n",
+	},
+}
+
+// TestTrimTrailingSpace
+func TestTrimTrailingSpace(t *testing.T) {
+	for _, tt := range trimTrailingSpaceCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader("a,bb\nccc,d"), out, comma, TextQualifier{})
+		a.UpdatePadding(tt.po)
+		a.Align()
+
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() with TrimTrailingSpace = %q; want %q", got, tt.expected)
+		}
+	}
+}
+
+var directiveCases = []struct {
+	input     string
+	expected  string
+	shouldErr bool
+}{
+	{
+		"#align: sep=| pad=2 just=right\na|bb\nccc|d",
+		"  a  |  bb  \nccc  |   d  \n",
+		false,
+	},
+	{
+		"#align: sep=| out=;\na|bb\nccc|d",
+		"a   ; bb \nccc ; d  \n",
+		false,
+	},
+	{
+		"#align: qual='\n'a,b',c\nd,e",
+		"'a,b' , c \nd     , e \n",
+		false,
+	},
+	{
+		"a,b\n#align: sep=|",
+		"a             , b \n#align: sep=| \n",
+		false,
+	},
+	{
+		"#align: just=diagonal\na,b",
+		"",
+		true,
+	},
+	{
+		"#align: pad=x\na,b",
+		"",
+		true,
+	},
+	{
+		"#align: color=red\na,b",
+		"",
+		true,
+	},
+}
+
+// TestDirective
+func TestDirective(t *testing.T) {
+	for _, tt := range directiveCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader(tt.input), out, comma, TextQualifier{})
+		a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1, Directive: true})
+		err := a.Align()
+
+		if tt.shouldErr {
+			if err == nil {
+				t.Fatalf("Align() with directive %q should return an error", tt.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Align() with directive %q returned error: %v", tt.input, err)
+		}
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() with directive %q = %q; want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+// TestSetJustifications
+func TestSetJustifications(t *testing.T) {
+	out := &bytes.Buffer{}
+	a := NewAlign(strings.NewReader("a,b,c,d\nxxx,yyy,zzzzz,www"), out, comma, TextQualifier{})
+	a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1})
+	if err := a.SetJustifications("lrc"); err != nil {
+		t.Fatalf("SetJustifications() returned error: %v", err)
+	}
+	a.Align()
+
+	expected := "a   ,   b ,   c   ,  d  \nxxx , yyy , zzzzz , www \n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Align() with SetJustifications(\"lrc\") = %q; want %q", got, expected)
+	}
+
+	for _, spec := range []string{"", "lx"} {
+		if err := a.SetJustifications(spec); err == nil {
+			t.Fatalf("SetJustifications(%q) should return an error", spec)
+		}
+	}
+}
+
+var footerAggregateCases = []struct {
+	aggs      map[int]string
+	po        PaddingOpts
+	expected  string
+	shouldErr bool
+}{
+	{map[int]string{0: "sum"}, PaddingOpts{Justification: JustifyLeft}, "", true},
+	{map[int]string{-1: "count", 2: "sum"}, PaddingOpts{Justification: JustifyLeft}, "", true},
+	{
+		map[int]string{2: "sum", 3: "avg"},
+		PaddingOpts{Justification: JustifyRight, Pad: 1, HeaderLines: 1},
+		"item , qty , price \n   a ,   2 ,   1.5 \n   b ,  10 ,  2.25 \n   c ,   x ,     3 \n     ,  12 ,  2.25 \n",
+		false,
+	},
+	{
+		map[int]string{1: "count", 2: "min", 3: "max"},
+		PaddingOpts{Justification: JustifyLeft, Pad: 1, HeaderLines: 1},
+		"item , qty , price \na    , 2   , 1.5   \nb    , 10  , 2.25  \nc    , x   , 3     \n3    , 2   , 3.00  \n",
+		false,
+	},
+	{
+		map[int]string{1: "sum"},
+		PaddingOpts{Justification: JustifyLeft, Pad: 1, HeaderLines: 1, RowNumbers: true},
+		"1 , item , qty , price \n2 , a    , 2   , 1.5   \n3 , b    , 10  , 2.25  \n4 , c    , x   , 3     \n  ,      ,     ,       \n",
+		false,
+	},
+	{
+		map[int]string{3: "sum"},
+		PaddingOpts{Justification: JustifyLeft, Pad: 1},
+		"item , qty , price \na    , 2   , 1.5   \nb    , 10  , 2.25  \nc    , x   , 3     \n     ,     , 6.75  \n",
+		false,
+	},
+	{
+		map[int]string{1: "median"},
+		PaddingOpts{},
+		"",
+		true,
+	},
+}
+
+var looksLikeHeaderCases = []struct {
+	input    string
+	expected bool
+}{
+	{"item,qty,price\na,2,1.5\nb,10,2.25", true},
+	{"name,city\nJo,Paris\nLi,Rome", false},
+	{"1,2\n3,4", false},
+	{"id,,note\n1,,x\n2,,", true},
+	{"year,total\n2024,x", true},
+	{"only,line", false},
+	{"", false},
+}
+
+// TestLooksLikeHeader
+func TestLooksLikeHeader(t *testing.T) {
+	for _, tt := range looksLikeHeaderCases {
+		a := NewAlign(strings.NewReader(tt.input), &bytes.Buffer{}, comma, TextQualifier{})
+		a.Align()
+
+		if got := a.LooksLikeHeader(); got != tt.expected {
+			t.Fatalf("LooksLikeHeader() of %q = %v; want %v", tt.input, got, tt.expected)
+		}
+	}
+}
+
+// TestFooterAggregate
+func TestFooterAggregate(t *testing.T) {
+	for _, tt := range footerAggregateCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader("item,qty,price\na,2,1.5\nb,10,2.25\nc,x,3"), out, comma, TextQualifier{})
+		tt.po.FooterAggregate = tt.aggs
+		a.UpdatePadding(tt.po)
+		err := a.Align()
+
+		if tt.shouldErr {
+			if err == nil {
+				t.Fatalf("Align() with FooterAggregate %v should return an error", tt.aggs)
+			}
+			continue
+		}
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() with FooterAggregate %v = %q; want %q", tt.aggs, got, tt.expected)
+		}
+	}
+}
+
+// TestWidthSources
+func TestWidthSources(t *testing.T) {
+	a := NewAlign(strings.NewReader("id,name\n1,Al\n22,Bartholomew\n333,Bo"), &bytes.Buffer{}, comma, TextQualifier{})
+	a.Align()
+
+	expected := map[int]WidthSource{
+		1: {Line: 4, Value: "333"},
+		2: {Line: 3, Value: "Bartholomew"},
+	}
+	got := a.WidthSources()
+	if len(got) != len(expected) {
+		t.Fatalf("WidthSources() = %v; want %v", got, expected)
+	}
+	for k, v := range expected {
+		if got[k] != v {
+			t.Fatalf("WidthSources()[%v] = %v; want %v", k, got[k], v)
+		}
+	}
+}
+
+var trimCases = []struct {
+	po       PaddingOpts
+	expected string
+}{
+	{
+		PaddingOpts{Justification: JustifyLeft, Pad: 1, TrimRight: true},
+		"  a , b     \nccc ,   ddd \n",
+	},
+	{
+		PaddingOpts{Justification: JustifyLeft, Pad: 1, TrimLeft: true},
+		"a    , b   \nccc  , ddd \n",
+	},
+	{
+		PaddingOpts{Justification: JustifyLeft, Pad: 1, TrimLeft: true, TrimRight: true},
+		"a   , b   \nccc , ddd \n",
+	},
+}
+
+// TestTrim
+func TestTrim(t *testing.T) {
+	for _, tt := range trimCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader("  a   ,b\nccc,  ddd"), out, comma, TextQualifier{})
+		a.UpdatePadding(tt.po)
+		a.Align()
+
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() with %+v = %q; want %q", tt.po, got, tt.expected)
+		}
+	}
+}
+
+// TestGroupSep
+func TestGroupSep(t *testing.T) {
+	input := "a,b|c,d\nlong,x|y,longer,z\nq|r"
+	out := &bytes.Buffer{}
+	a := NewAlign(strings.NewReader(input), out, comma, TextQualifier{})
+	a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1, GroupSep: "|", TrimTrailingSpace: true})
+	a.Align()
+
+	expected := "a    , b | c , d\nlong , x | y , longer , z\nq        | r\n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Align() with GroupSep = %q; want %q", got, expected)
+	}
+}
+
+// TestAlignWith
+func TestAlignWith(t *testing.T) {
+	out := &bytes.Buffer{}
+	a := NewAlign(strings.NewReader("a,bb,c\nddd,e,f"), out, comma, TextQualifier{})
+	a.OutputSep("|")
+	a.FilterColumns([]int{1, 2, 3})
+	err := a.AlignWith(RunOptions{
+		OutputSep:      ";",
+		Filter:         []int{1, 2},
+		Justification:  JustifyRight,
+		ColumnOverride: map[int]Justification{2: JustifyLeft},
+	})
+	if err != nil {
+		t.Fatalf("AlignWith() returned error: %v", err)
+	}
+
+	expected := "  a ; bb \nddd ; e  \n"
+	if got := out.String(); got != expected {
+		t.Fatalf("AlignWith() = %q; want %q", got, expected)
+	}
+
+	out = &bytes.Buffer{}
+	c := a.Clone(strings.NewReader("a,bb,c\nddd,e,f"), out)
+	if err := c.AlignWith(RunOptions{Filter: []int{2, 3}}); err != nil {
+		t.Fatalf("AlignWith() of a clone returned error: %v", err)
+	}
+	expected = "bb | c \ne  | f \n"
+	if got := out.String(); got != expected {
+		t.Fatalf("AlignWith() of a clone = %q; want %q", got, expected)
+	}
+	if err := a.AlignWith(RunOptions{}); err != ErrAligned {
+		t.Fatalf("AlignWith() after AlignWith() = %v; want %v", err, ErrAligned)
+	}
+}
+
+// TestOutputSepWidth
+func TestOutputSepWidth(t *testing.T) {
+	for _, tt := range outputSepWidthCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader("a,bb,c\ndddd,e,f\nx"), out, comma, TextQualifier{})
+		a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: tt.pad})
+		a.OutputSep(tt.sepOut)
+		a.FilterColumns(tt.filter)
+		a.Align()
+
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() with output separator %q, pad %v and filter %v = %q; want %q", tt.sepOut, tt.pad, tt.filter, got, tt.expected)
+		}
+	}
+}
+
+// TestSplit
+func TestSplit(t *testing.T) {
+	for _, tt := range qualifiedSplitCases {
+		a := NewAlign(strings.NewReader(tt.input), os.Stdout, comma, TextQualifier{On: true, Qualifier: "\""})
+		got := a.splitWithQual(tt.input, tt.sep, tt.qual)
+
+		if len(got) != tt.expected {
+			t.Fatalf("splitWithQual(%v, %v, %v) = %v; want %v", tt.input, tt.sep, tt.qual, len(got), tt.expected)
+		}
+	}
+}
+
+var centerPaddingCases = []struct {
+	padLength int
+	bias      CenterBias
+	expected  string
+}{
+	{0, CenterBiasRight, "|ab|"},
+	{1, CenterBiasRight, "| ab|"},
+	{1, CenterBiasLeft, "|ab |"},
+	{2, CenterBiasRight, "| ab |"},
+	{2, CenterBiasLeft, "| ab |"},
+	{3, CenterBiasRight, "|  ab |"},
+	{3, CenterBiasLeft, "| ab  |"},
+	{4, CenterBiasLeft, "|  ab  |"},
+}
+
+// dotPad fills padding with the rune r.
+type dotPad struct {
+	fieldPad
+	r rune
+}
+
+func (p *dotPad) PadChar() rune { return p.r }
+
+var padCharCases = []struct {
+	r        rune
+	po       PaddingOpts
+	expected string
+}{
+	{'·', PaddingOpts{Justification: JustifyLeft, Pad: 1}, "a··· , b \ncccc , d \n"},
+	{'·', PaddingOpts{Justification: JustifyRight, Pad: 1}, "···a , b \ncccc , d \n"},
+	{'＿', PaddingOpts{Justification: JustifyLeft, Pad: 1}, "a＿  , b \ncccc , d \n"},
+	{'＿', PaddingOpts{Justification: JustifyCenter, Pad: 1}, "＿a  , b \ncccc , d \n"},
+	{'·', PaddingOpts{Justification: JustifyLeft, Pad: 1, TrimTrailingSpace: true}, "a··· , b\ncccc , d\n"},
+}
+
+// TestPadChar
+func TestPadChar(t *testing.T) {
+	for _, tt := range padCharCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader("a,b\ncccc,d"), out, comma, TextQualifier{})
+		a.UpdatePadding(tt.po)
+		a.UpdatePadder(&dotPad{r: tt.r})
+		a.Align()
+
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() with PadChar %q and justification %d = %q; want %q", tt.r, tt.po.Justification, got, tt.expected)
+		}
+	}
+}
+
+// TestCenterBias
+func TestCenterBias(t *testing.T) {
+	for _, tt := range centerPaddingCases {
+		p := &fieldPad{}
+		got := "|" + string(applyPadding(p, "ab", "", "", 0, tt.padLength, JustifyCenter, tt.bias)) + "|"
+		if got != tt.expected {
+			t.Fatalf("applyPadding() centered with padding %d and bias %d = %q; want %q", tt.padLength, tt.bias, got, tt.expected)
+		}
+		if n := trailingPadLen(p, "", tt.padLength, JustifyCenter, tt.bias); n != len(got)-len(strings.TrimRight(got[:len(got)-1], " "))-1 {
+			t.Fatalf("trailingPadLen() with padding %d and bias %d = %d; want it to match %q", tt.padLength, tt.bias, n, got)
+		}
+	}
+}
+
+// TestPad
+func TestPad(t *testing.T) {
+	for _, tt := range paddingCases {
+		padLen := countPadding(tt.input, tt.columnCount)
+		got := applyPadding(tt.pad, tt.input, " ", " ", 1, padLen, tt.po.Justification, tt.po.CenterBias)
+
+		if len(got) != tt.expected {
+			t.Fatalf("pad(%v) =%v; want %v", tt.input, got, tt.expected)
+		}
+	}
+}
+
+// TestColumnCounts
+func TestColumnCounts(t *testing.T) {
+	for _, tt := range columnCountCases {
+		a := NewAlign(strings.NewReader(tt.input), os.Stdout, tt.sep, TextQualifier{On: tt.isQual, Qualifier: tt.qual})
+		a.columnLength()
+		for i := range tt.counts {
+			if a.columnSize(i) != tt.counts[i] {
+				t.Fatalf("Count for column %v = %v, want %v", i, a.columnSize(i), tt.counts[i])
+			}
+		}
+	}
+}
+
+// TestFieldLenEscaped
+func TestFieldLenEscaped(t *testing.T) {
+	for _, tt := range fieldLenEscapedCases {
+		got := fieldLenEscaped(tt.input, tt.sep, tt.qual)
+		if got != tt.expected {
+			t.Fatalf("FieldLenEscaped(%v) = %v; want %v", tt.input, got, tt.expected)
+		}
+	}
+}
+
+// TestFieldLen
+func TestFieldLen(t *testing.T) {
+	for _, tt := range fieldLenCases {
+		got := fieldLen(tt.input, tt.sep)
+		if got != tt.expected {
+			t.Fatalf("FieldLen(%v) = %v; want %v", tt.input, got, tt.expected)
+		}
+	}
+}
+
+// TestCountPadding
+func TestCountPadding(t *testing.T) {
+	for _, tt := range countPaddingCases {
+		got := countPadding(tt.input, tt.fieldLen)
+		if got != tt.expected {
+			t.Fatalf("countPadding(%v) = %v; want %v", tt.input, got, tt.expected)
+		}
+	}
+}
+
+// TestGenFieldLen
+func TestGenFieldLen(t *testing.T) {
+
+	for _, tt := range genFieldLenCases {
+		got := genFieldLen(tt.input, tt.sep, tt.qual)
+
+		if got != tt.expected {
+			t.Fatalf("genFieldLen(%v, %v, %v) = %v; want %v", tt.input, tt.sep, tt.qual, got, tt.expected)
+		}
+	}
+}
+
+// TestSplitMultiCharSepQual
+func TestSplitMultiCharSepQual(t *testing.T) {
+	for _, tt := range multiCharSepQualCases {
+		a := NewAlign(strings.NewReader(tt.input), &bytes.Buffer{}, " | ", TextQualifier{On: true, Qualifier: "\""})
+		got := a.splitWithQual(tt.input, " | ", "\"")
+
+		if strings.Join(got, "\x00") != strings.Join(tt.expected, "\x00") {
+			t.Fatalf("splitWithQual(%q) = %q; want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+// TestEmptyQualifiedField
+func TestEmptyQualifiedField(t *testing.T) {
+	for _, tt := range emptyQualifiedFieldCases {
+		a := NewAlign(strings.NewReader(tt.input), &bytes.Buffer{}, comma, TextQualifier{On: true, Qualifier: "\""})
+
+		got := a.splitWithQual(tt.input, comma, "\"")
+		if strings.Join(got, "\x00") != strings.Join(tt.expected, "\x00") {
+			t.Fatalf("splitWithQual(%q) = %q; want %q", tt.input, got, tt.expected)
+		}
+
+		a.columnLength()
+		for i := range tt.counts {
+			if a.columnSize(i) != tt.counts[i] {
+				t.Fatalf("Count for column %v of %q = %v, want %v", i, tt.input, a.columnSize(i), tt.counts[i])
+			}
+		}
+	}
+}
+
+// TestSkipRegions
+func TestSkipRegions(t *testing.T) {
+	for _, tt := range skipRegionCases {
+		a := NewAlign(strings.NewReader(tt.input), &bytes.Buffer{}, tt.sep, TextQualifier{})
+		a.SkipRegions(CodeRegions)
+		got := a.splitWithQual(tt.input, tt.sep, "")
+
+		if strings.Join(got, "\x00") != strings.Join(tt.expected, "\x00") {
+			t.Fatalf("splitWithQual(%q) with CodeRegions = %q; want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestSkipRegions_Align(t *testing.T) {
+	input := "x = 1\nlonger = \"a=b\" // c=d\n// y = 2"
+	out := &bytes.Buffer{}
+	a := NewAlign(strings.NewReader(input), out, "=", TextQualifier{})
+	a.SkipRegions(CodeRegions)
+	a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, ReAlign: true, PadLeft: 1, PadRight: 1})
+	a.Align()
+
+	expected := "x        = 1            \nlonger   = \"a=b\" // c=d \n// y = 2 \n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Align() with CodeRegions = %q; want %q", got, expected)
+	}
+}
+
+// TestMaxSplits
+func TestMaxSplits(t *testing.T) {
+	for _, tt := range maxSplitsCases {
+		a := NewAlign(strings.NewReader(tt.input), &bytes.Buffer{}, tt.sep, tt.qu)
+		a.UpdatePadding(PaddingOpts{MaxSplits: tt.n})
+		a.SkipRegions(tt.regions)
+		got := a.splitWithQual(tt.input, tt.sep, tt.qu.Qualifier)
+
+		if strings.Join(got, "\x00") != strings.Join(tt.expected, "\x00") {
+			t.Fatalf("splitWithQual(%q) with MaxSplits %v = %q; want %q", tt.input, tt.n, got, tt.expected)
+		}
+	}
+}
+
+// TestGroupThousands
+func TestGroupThousands(t *testing.T) {
+	for _, tt := range groupThousandsCases {
+		if got := groupThousands(tt.input, tt.sep); got != tt.expected {
+			t.Fatalf("groupThousands(%q, %q) = %q; want %q", tt.input, tt.sep, got, tt.expected)
+		}
+	}
+
+	out := &bytes.Buffer{}
+	a := NewAlign(strings.NewReader("item|amount\nrent|1500.00\ncar|25000"), out, "|", TextQualifier{})
+	a.UpdatePadding(PaddingOpts{Justification: JustifyRight, Pad: 1, GroupThousands: true})
+	a.Align()
+
+	expected := "item |   amount \nrent | 1,500.00 \n car |   25,000 \n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Align() with GroupThousands = %q; want %q", got, expected)
+	}
+}
+
+// TestZeroPad
+func TestZeroPad(t *testing.T) {
+	out := &bytes.Buffer{}
+	a := NewAlign(strings.NewReader("id,qty,name\n7,1,a\n-12,1000,bb\n1.5,n/a,c\nx,3,1"), out, comma, TextQualifier{})
+	a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1, ZeroPad: map[int]bool{1: true, 2: true}})
+	a.Align()
+
+	expected := "id  , qty  , name \n007 , 0001 , a    \n-12 , 1000 , bb   \n1.5 , n/a  , c    \nx   , 0003 , 1    \n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Align() with ZeroPad = %q; want %q", got, expected)
+	}
+}
+
+// TestSplitFromRight
+func TestSplitFromRight(t *testing.T) {
+	for _, tt := range splitFromRightCases {
+		a := NewAlign(strings.NewReader(tt.input), &bytes.Buffer{}, tt.sep, tt.qu)
+		a.UpdatePadding(PaddingOpts{MaxSplits: tt.n, SplitFromRight: true})
+		got := a.splitWithQual(tt.input, tt.sep, tt.qu.Qualifier)
+
+		if strings.Join(got, "\x00") != strings.Join(tt.expected, "\x00") {
+			t.Fatalf("splitWithQual(%q) with SplitFromRight = %q; want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+var displayWidthCases = []struct {
+	input    string
+	expected int
+}{
+	{"", 0},
+	{"plain ascii", 11},
+	{"Märsta", 6},
+	{"世界", 4},
+	{"a\tb", 2},
+}
+
+// TestDisplayWidth
+func TestDisplayWidth(t *testing.T) {
+	for _, tt := range displayWidthCases {
+		if got := displayWidth(tt.input); got != tt.expected {
+			t.Fatalf("displayWidth(%q) = %d; want %d", tt.input, got, tt.expected)
+		}
+	}
+}
+
+// TestTruncate
+func TestTruncate(t *testing.T) {
+	for _, tt := range truncateCases {
+		if got := truncate(tt.input, tt.width, tt.anchor); got != tt.expected {
+			t.Fatalf("truncate(%q, %d, %d) = %q; want %q", tt.input, tt.width, tt.anchor, got, tt.expected)
+		}
+	}
+
+	out := &bytes.Buffer{}
+	a := NewAlign(strings.NewReader("file,size\n/usr/local/share/doc/readme,12\nmain.go,3"), out, comma, TextQualifier{})
+	a.UpdatePadding(PaddingOpts{
+		Justification:  JustifyLeft,
+		MaxColWidth:    map[int]int{1: 10},
+		TruncateAnchor: map[int]TruncateAnchor{1: TruncateStart},
+	})
+	a.Align()
+
+	expected := "file        ,size\n…oc/readme  ,12  \nmain.go     ,3   \n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Align() with MaxColWidth = %q; want %q", got, expected)
+	}
+}
+
+var fieldLengthCases = []struct {
+	input    string
+	sep      string
+	qual     string
+	expected int
+}{
+	{"first,last", ",", "", 5},
+	{"no separator", ",", "", 12},
+	{"\"Smith, Jo\",42", ",", "\"", 11},
+	{"\"Smith, Jo\",42", ",", "", 6},
+	{"\"unterminated, field", ",", "\"", 20},
+	{",leading", ",", "", 0},
+}
+
+var escapeCharCases = []struct {
+	input    string
+	txtq     TextQualifier
+	expected []string
+}{
+	{`a\,b,c`, TextQualifier{}, []string{`a\,b`, "c"}},
+	{`a\\,b`, TextQualifier{}, []string{`a\\`, "b"}},
+	{`a,b\,`, TextQualifier{}, []string{"a", `b\,`}},
+	{`a,,b`, TextQualifier{}, []string{"a", "", "b"}},
+	{`"x,y",a\,b,c`, TextQualifier{On: true, Qualifier: "\""}, []string{`"x,y"`, `a\,b`, "c"}},
+}
+
+var runeQualifierCases = []struct {
+	input    string
+	txtq     TextQualifier
+	expected []string
+}{
+	{"[a,b],c", TextQualifier{On: true, Open: '[', Close: ']'}, []string{"[a,b]", "c"}},
+	{"x,[a]b],c", TextQualifier{On: true, Open: '[', Close: ']'}, []string{"x", "[a]b]", "c"}},
+	{"[unterminated,c", TextQualifier{On: true, Open: '[', Close: ']'}, []string{"[unterminated,c"}},
+	{"«a,b»,c", TextQualifier{On: true, Open: '«', Close: '»'}, []string{"«a,b»", "c"}},
+	{"|a,b|,c", TextQualifier{On: true, Open: '|'}, []string{"|a,b|", "c"}},
+	{"'a,b',\"\",c", TextQualifier{On: true, Qualifier: "'"}, []string{"'a,b'", "\"\"", "c"}},
+	{"'',x", TextQualifier{On: true, Qualifier: "'"}, []string{"''", "x"}},
+}
+
+// TestRuneQualifier
+func TestRuneQualifier(t *testing.T) {
+	for _, tt := range runeQualifierCases {
+		a := NewAlign(strings.NewReader(""), &bytes.Buffer{}, comma, tt.txtq)
+
+		if got := a.Split(tt.input); strings.Join(got, "\x00") != strings.Join(tt.expected, "\x00") {
+			t.Fatalf("Split(%q) with %+v = %q; want %q", tt.input, tt.txtq, got, tt.expected)
+		}
+	}
+}
+
+var exportedSplitCases = []struct {
+	input    string
+	txtq     TextQualifier
+	po       PaddingOpts
+	expected []string
+}{
+	{"a,b,c", TextQualifier{}, PaddingOpts{}, []string{"a", "b", "c"}},
+	{"a,\"b,c\",d", TextQualifier{}, PaddingOpts{}, []string{"a", "\"b", "c\"", "d"}},
+	{"a,\"b,c\",d", TextQualifier{On: true, Qualifier: "\""}, PaddingOpts{}, []string{"a", "\"b,c\"", "d"}},
+	{"a , b,c", TextQualifier{}, PaddingOpts{MaxSplits: 2}, []string{"a ", " b,c"}},
+	{"a,b,c", TextQualifier{}, PaddingOpts{SplitFromRight: true}, []string{"a,b", "c"}},
+	{"", TextQualifier{}, PaddingOpts{}, []string{""}},
+}
+
+// TestFieldLength
+func TestFieldLength(t *testing.T) {
+	for _, tt := range fieldLengthCases {
+		if got := FieldLength(tt.input, tt.sep, tt.qual); got != tt.expected {
+			t.Fatalf("FieldLength(%q, %q, %q) = %d; want %d", tt.input, tt.sep, tt.qual, got, tt.expected)
+		}
+	}
+}
+
+// TestEscapeChar
+func TestEscapeChar(t *testing.T) {
+	for _, tt := range escapeCharCases {
+		a := NewAlign(strings.NewReader(""), &bytes.Buffer{}, comma, tt.txtq)
+		a.UpdatePadding(PaddingOpts{EscapeChar: '\\'})
+
+		if got := a.Split(tt.input); strings.Join(got, "\x00") != strings.Join(tt.expected, "\x00") {
+			t.Fatalf("Split(%q) with EscapeChar = %q; want %q", tt.input, got, tt.expected)
+		}
+	}
+
+	out := &bytes.Buffer{}
+	a := NewAlign(strings.NewReader("city,population\nSmallville\\, KS,45001\nGotham,8000000"), out, comma, TextQualifier{})
+	a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1, EscapeChar: '\\', Unescape: true})
+	a.Align()
+
+	expected := "city           , population \nSmallville, KS , 45001      \nGotham         , 8000000    \n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Align() with Unescape = %q; want %q", got, expected)
+	}
+}
+
+// TestExportedSplit
+func TestExportedSplit(t *testing.T) {
+	for _, tt := range exportedSplitCases {
+		a := NewAlign(strings.NewReader(""), &bytes.Buffer{}, comma, tt.txtq)
+		a.UpdatePadding(tt.po)
+
+		if got := a.Split(tt.input); strings.Join(got, "\x00") != strings.Join(tt.expected, "\x00") {
+			t.Fatalf("Split(%q) = %q; want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+// TestQuoteField
+func TestQuoteField(t *testing.T) {
+	for _, tt := range quoteFieldCases {
+		if got := quoteField(tt.input, tt.sep, tt.qual); got != tt.expected {
+			t.Fatalf("quoteField(%q, %q, %q) = %q; want %q", tt.input, tt.sep, tt.qual, got, tt.expected)
+		}
+	}
+
+	out := &bytes.Buffer{}
+	a := NewAlign(strings.NewReader("name|size\nfloppy, 3.5|\"3.5\"\" disk\"\nhd|1,000"), out, "|", TextQualifier{On: true, Qualifier: "\""})
+	a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 0, QuoteOnOutput: true})
+	a.OutputSep(",")
+	a.Align()
+
+	expected := "name         ,size        \n\"floppy, 3.5\",\"3.5\"\" disk\"\nhd           ,\"1,000\"     \n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Align() with QuoteOnOutput = %q; want %q", got, expected)
+	}
+}
+
+func TestGenFieldLen_Failure(t *testing.T) {
+	got := genFieldLen("", "", "")
+	expected := 0
+	if got != expected {
+		t.Fatalf(`genFieldLen("", "", "") = %v; want %v`, got, expected)
+	}
+}
+
+var fillWithPaddingCases = []int{0, -1, 1, 255, 256, 257, 1000}
+
+// TestFillWithPadding
+func TestFillWithPadding(t *testing.T) {
+	for _, n := range fillWithPaddingCases {
+		p := &fieldPad{}
+		fillWithPadding(p, n)
+
+		expected := 0
+		if n > 0 {
+			expected = n
+		}
+		if p.Len() != expected || strings.Trim(p.String(), " ") != "" {
+			t.Fatalf("fillWithPadding(%v) wrote %q; want %v spaces", n, p.String(), expected)
+		}
+	}
+}
+
+// BenchmarkColumnCounts
+func BenchmarkColumnCounts(b *testing.B) {
+	input := `First,Middle,Last,Email,Region,City,Zip,Full_Name,First,Middle,Last,Email,Region,City,Zip,Full_Name,First,Middle,Last,Email,Region,City,Zip,Full_Name
+Karleigh,Destiny,Dean,nunc.In@lorem.edu,Stockholms län,Märsta,9038,Shaine Reilly,Karleigh,Destiny,Dean,nunc.In@lorem.edu,Stockholms län,Märsta,9038,Shaine Reilly,Karleigh,Destiny,Dean,nunc.In@lorem.edu,Stockholms län,Märsta,9038,Shaine Reilly        
+Alisa,Walker,Armand,Sed@Nuncmauriselit.com,Himachal Pradesh,Shimla,MZ0 4QS,Olivia Velez ,Alisa,Walker,Armand,Sed@Nuncmauriselit.com,Himachal Pradesh,Shimla,MZ0 4QS,Olivia Velez ,Alisa,Walker,Armand,Sed@Nuncmauriselit.com,Himachal Pradesh,Shimla,MZ0 4QS,Olivia Velez
+Karleigh,Destiny,Dean,nunc.In@lorem.edu,Stockholms län,Märsta,9038,Shaine Reilly,Karleigh,Destiny,Dean,nunc.In@lorem.edu,Stockholms län,Märsta,9038,Shaine Reilly,Karleigh,Destiny,Dean,nunc.In@lorem.edu,Stockholms län,Märsta,9038,Shaine Reilly        
+Alisa,Walker,Armand,Sed@Nuncmauriselit.com,Himachal Pradesh,Shimla,MZ0 4QS,Olivia Velez ,Alisa,Walker,Armand,Sed@Nuncmauriselit.com,Himachal Pradesh,Shimla,MZ0 4QS,Olivia Velez ,Alisa,Walker,Armand,Sed@Nuncmauriselit.com,Himachal Pradesh,Shimla,MZ0 4QS,Olivia Velez
+Karleigh,Destiny,Dean,nunc.In@lorem.edu,Stockholms län,Märsta,9038,Shaine Reilly,Karleigh,Destiny,Dean,nunc.In@lorem.edu,Stockholms län,Märsta,9038,Shaine Reilly,Karleigh,Destiny,Dean,nunc.In@lorem.edu,Stockholms län,Märsta,9038,Shaine Reilly
+Alisa,Walker,Armand,Sed@Nuncmauriselit.com,Himachal Pradesh,Shimla,MZ0 4QS,Olivia Velez ,Alisa,Walker,Armand,Sed@Nuncmauriselit.com,Himachal Pradesh,Shimla,MZ0 4QS,Olivia Velez ,Alisa,Walker,Armand,Sed@Nuncmauriselit.com,Himachal Pradesh,Shimla,MZ0 4QS,Olivia Velez
+`
+
+	a := NewAlign(strings.NewReader(input), &bytes.Buffer{}, comma, TextQualifier{On: false})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		a.columnLength()
+	}
+}
+
+// BenchmarkSplitWithQual
+func BenchmarkSplitWithQual(b *testing.B) {
+	input := "First,\"Middle, name\",Last,Email,Region,City,Zip,Full_Name"
+
+	a := NewAlign(strings.NewReader(input), &bytes.Buffer{}, comma, TextQualifier{On: true, Qualifier: "\""})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		a.splitWithQual(input, comma, "\"")
+	}
+}
+
+// BenchmarkSplitWithQualNoQual
+func BenchmarkSplitWithQualNoQual(b *testing.B) {
+	input := "First,Middle,Last,Email,Region,City,Zip,Full_Name"
+
+	a := NewAlign(strings.NewReader(input), &bytes.Buffer{}, comma, TextQualifier{On: false})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		a.splitWithQual(input, comma, "\"")
+	}
+}
+
+func BenchmarkExport(b *testing.B) {
+	input := "First,Middle,Last,Email,Region,City,Zip,Full_Name"
+
+	a := NewAlign(strings.NewReader(input), &bytes.Buffer{}, comma, TextQualifier{On: false})
+	a.columnLength()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		a.export()
+	}
+}
+
+// BenchmarkAlignASCII
+func BenchmarkAlignASCII(b *testing.B) {
+	input := strings.Repeat("First,Middle,Last,Email,Region,City,Zip,Full_Name\nKarleigh,Destiny,Dean,nunc.In@lorem.edu,Stockholm,Marsta,9038,Shaine Reilly\n", 500)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		a := NewAlign(strings.NewReader(input), io.Discard, comma, TextQualifier{})
+		a.Align()
+	}
+}
+
+// BenchmarkFillWithPadding
+func BenchmarkFillWithPadding(b *testing.B) {
+	p := &fieldPad{}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		fillWithPadding(p, 120)
+		p.Reset()
+	}
+}
+
+var padPatternCases = []struct {
+	pattern  string
+	po       PaddingOpts
+	expected string
+}{
+	{"-=", PaddingOpts{Justification: JustifyLeft, Pad: 1}, "a-=-=-= , b \nccccccc , d \n"},
+	{"-=", PaddingOpts{Justification: JustifyRight, Pad: 1}, "-=-=-=a , b \nccccccc , d \n"},
+	{"-=", PaddingOpts{Justification: JustifyCenter, Pad: 1}, "-=-a-=- , b \nccccccc , d \n"},
+	{"＿＿-", PaddingOpts{Justification: JustifyLeft, Pad: 1}, "a＿＿-  , b \nccccccc , d \n"},
+	{".", PaddingOpts{Justification: JustifyLeft, Pad: 1}, "a...... , b \nccccccc , d \n"},
+}
+
+// TestPadPattern
+func TestPadPattern(t *testing.T) {
+	for _, tt := range padPatternCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader("a,b\nccccccc,d"), out, comma, TextQualifier{})
+		tt.po.PadPattern = tt.pattern
+		a.UpdatePadding(tt.po)
+		a.Align()
+
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() with PadPattern %q and justification %d = %q; want %q", tt.pattern, tt.po.Justification, got, tt.expected)
+		}
+	}
+}
+
+var sepHugCases = []struct {
+	po       PaddingOpts
+	expected string
+}{
+	{PaddingOpts{Justification: JustifyLeft, Pad: 1}, "a    : 1  \nlong : 22 \nb    \n"},
+	{PaddingOpts{Justification: JustifyLeft, Pad: 1, SepHug: SepHugLeft}, "a:     1  \nlong:  22 \nb     \n"},
+	{PaddingOpts{Justification: JustifyLeft, PadRight: 1, SepHug: SepHugLeft}, "a:    1 \nlong: 22\nb    \n"},
+	{PaddingOpts{Justification: JustifyRight, Pad: 1, SepHug: SepHugLeft}, "   a:   1 \nlong:  22 \n    b \n"},
+	{PaddingOpts{Justification: JustifyLeft, Pad: 1, SepHug: SepHugRight}, "a     :1  \nlong  :22 \nb    \n"},
+	{PaddingOpts{Justification: JustifyLeft, Pad: 1, SepHug: SepHugLeft, SepWidth: 2}, "a:      1  \nlong:   22 \nb      \n"},
+	{PaddingOpts{Justification: JustifyLeft, Pad: 1, SepHug: SepHugLeft, RowNumbers: true}, "1:  a:     1  \n2:  long:  22 \n3:  b     \n"},
+	{PaddingOpts{Justification: JustifyLeft, Pad: 1, SepHug: SepHugRight, RowNumbers: true}, "1  :a     :1  \n2  :long  :22 \n3  :b    \n"},
+}
+
+// TestSepHug
+func TestSepHug(t *testing.T) {
+	for _, tt := range sepHugCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader("a:1\nlong:22\nb"), out, ":", TextQualifier{})
+		a.UpdatePadding(tt.po)
+		a.Align()
+
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() with SepHug %d = %q; want %q", tt.po.SepHug, got, tt.expected)
+		}
+	}
+}
+
+var sepHugWidthCases = []struct {
+	po       PaddingOpts
+	filter   []int
+	expected string
+}{
+	{PaddingOpts{Justification: JustifyLeft, Pad: 1, SepHug: SepHugLeft}, []int{1, 2}, "aaa|  b   \nd|    eee \n"},
+	{PaddingOpts{Justification: JustifyLeft, Pad: 1, SepHug: SepHugRight, TrimTrailingSpace: true, SepWidth: 3}, nil, "aaa  |b      |c\nd    |eee    |fff\n"},
+	{PaddingOpts{Justification: JustifyLeft, Pad: 1, SepHug: SepHugRight, TrimTrailingSpace: true, SepWidth: 2}, nil, "aaa  |b     |c\nd    |eee   |fff\n"},
+	{PaddingOpts{Justification: JustifyRight, Pad: 1, SepHug: SepHugRight, TrimTrailingSpace: true, SepWidth: 3}, []int{1, 2}, "aaa      |b\n  d    |eee\n"},
+	{PaddingOpts{Justification: JustifyLeft, Pad: 1, SepHug: SepHugLeft, TrimTrailingSpace: true, SepWidth: 3}, nil, "aaa|    b|      c\nd|      eee|    fff\n"},
+}
+
+// TestSepHugWidth
+func TestSepHugWidth(t *testing.T) {
+	for _, tt := range sepHugWidthCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader("aaa,b,c\nd,eee,fff"), out, comma, TextQualifier{})
+		a.OutputSep("|")
+		a.UpdatePadding(tt.po)
+		a.FilterColumns(tt.filter)
+		a.Align()
+
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() with SepHug %d = %q; want %q", tt.po.SepHug, got, tt.expected)
+		}
+		var width int
+		for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+			if len(line) > width {
+				width = len(line)
+			}
+		}
+		if a.TotalWidth() != width {
+			t.Fatalf("TotalWidth() with SepHug %d = %d; want %d", tt.po.SepHug, a.TotalWidth(), width)
+		}
+	}
+}
+
+var tsvEmptyCellCases = []struct {
+	input    string
+	expected string
+}{
+	{"a\t\tc\nddd\te\tf", "a   |   | c \nddd | e | f \n"},        // interior
+	{"\tbb\tc\nddd\te\tf", "    | bb | c \nddd | e  | f \n"},     // leading
+	{"a\tbb\t\nddd\te\tf", "a   | bb |   \nddd | e  | f \n"},     // trailing
+	{"\t\tx\ny\t\t", "  |  | x \ny |  |   \n"},                   // several of each
+	{"a\t\t\tb\nc\td\te\tf", "a |   |   | b \nc | d | e | f \n"}, // consecutive
+}
+
+// TestTSVEmptyCells
+func TestTSVEmptyCells(t *testing.T) {
+	for _, tt := range tsvEmptyCellCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader(tt.input), out, "\t", TextQualifier{})
+		a.OutputSep("|")
+		a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1})
+		a.Align()
+
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() of %q = %q; want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+var layoutSpecCases = []struct {
+	input    string
+	po       PaddingOpts
+	expected string
+}{
+	{"x,y\nz,w", PaddingOpts{Justification: JustifyLeft, Pad: 1}, "x   , y  \nz   , w  \n"},
+	{"xxxxx,y\nz,w", PaddingOpts{Justification: JustifyLeft, Pad: 1}, "xx… , y  \nz   , w  \n"},
+	{"x,y,extra\nz,w", PaddingOpts{Justification: JustifyLeft, Pad: 1}, "x   , y  , extra \nz   , w  \n"},
+	{"x,y\nz,w", PaddingOpts{Justification: JustifyRight, Pad: 1}, "  x ,  y \n  z ,  w \n"},
+	{"x,y\nz,w", PaddingOpts{Justification: JustifyLeft, Pad: 1, TabStop: 4}, "x    , y    \nz    , w    \n"},
+}
+
+// TestLayoutSpec
+func TestLayoutSpec(t *testing.T) {
+	for _, tt := range layoutSpecCases {
+		a := NewAlign(strings.NewReader("a,bb\nccc,d"), &bytes.Buffer{}, comma, TextQualifier{})
+		a.UpdatePadding(tt.po)
+		a.Align()
+		spec := a.LayoutSpec()
+
+		out := &bytes.Buffer{}
+		NewAlignFromSpec(strings.NewReader(tt.input), out, spec).Align()
+
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() of %q with LayoutSpec %v = %q; want %q", tt.input, spec, got, tt.expected)
+		}
+	}
+}
+
+var columnQualifierCases = []struct {
+	input    string
+	txtq     TextQualifier
+	quals    map[int]string
+	expected string
+}{
+	{`"a,b",'c,d',e` + "\nf,g,h", TextQualifier{On: true, Qualifier: `"`}, map[int]string{2: "'"}, "\"a,b\" , 'c,d' , e \nf     , g     , h \n"},
+	{`"a,b",'c,d',e` + "\nf,g,h", TextQualifier{}, map[int]string{2: "'"}, "\"a , b\" , 'c , d' , e \nf  , g  , h  \n"},
+	{`'a,b',"c,d"` + "\nf,g", TextQualifier{On: true, Qualifier: `"`}, map[int]string{1: "'"}, "'a,b' , \"c,d\" \nf     , g     \n"},
+	{`x,"c,d"` + "\nf,g", TextQualifier{On: true, Qualifier: `"`}, map[int]string{2: ""}, "x , \"c , d\" \nf , g  \n"},
+}
+
+// TestColumnQualifier
+func TestColumnQualifier(t *testing.T) {
+	for _, tt := range columnQualifierCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader(tt.input), out, comma, tt.txtq)
+		a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1, ColumnQualifier: tt.quals})
+		a.Align()
+
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() of %q with ColumnQualifier %v = %q; want %q", tt.input, tt.quals, got, tt.expected)
+		}
+	}
+}
+
+var ruleAfterCases = []struct {
+	rows     []int
+	po       PaddingOpts
+	expected string
+}{
+	{nil, PaddingOpts{Justification: JustifyLeft, Pad: 1}, "name  , qty \napple , 3   \npear  , 12  \n"},
+	{[]int{1}, PaddingOpts{Justification: JustifyLeft, Pad: 1}, "name  , qty \n------------\napple , 3   \npear  , 12  \n"},
+	{[]int{1, 3}, PaddingOpts{Justification: JustifyLeft, Pad: 1, TrimTrailingSpace: true}, "name  , qty\n-----------\napple , 3\npear  , 12\n-----------\n"},
+	{[]int{2, 9}, PaddingOpts{Justification: JustifyLeft, Pad: 1, Indent: "  "}, "  name  , qty \n  apple , 3   \n  ------------\n  pear  , 12  \n"},
+	{[]int{1}, PaddingOpts{Justification: JustifyLeft, Pad: 1, Indent: "> ", TrimTrailingSpace: true}, "> name  , qty\n> -----------\n> apple , 3\n> pear  , 12\n"},
+}
+
+// TestRuleAfter
+func TestRuleAfter(t *testing.T) {
+	for _, tt := range ruleAfterCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader("name,qty\napple,3\npear,12"), out, comma, TextQualifier{})
+		a.UpdatePadding(tt.po)
+		a.RuleAfter(tt.rows)
+		a.Align()
+
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() with RuleAfter %v = %q; want %q", tt.rows, got, tt.expected)
+		}
+	}
+}
+
+var verbatimNoSepCases = []struct {
+	verbatim bool
+	filter   []int
+	expected string
+}{
+	{false, nil, "a            , bb \nsingle-token \nccc          , d  \n"},
+	{true, nil, "a   , bb \nsingle-token\nccc , d  \n"},
+	{false, []int{2}, "bb \n\nd  \n"},
+	{true, []int{2}, "bb \nsingle-token\nd  \n"},
+}
+
+// TestVerbatimNoSep
+func TestVerbatimNoSep(t *testing.T) {
+	for _, tt := range verbatimNoSepCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader("a,bb\nsingle-token\nccc,d"), out, comma, TextQualifier{})
+		a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1, VerbatimNoSep: tt.verbatim})
+		a.FilterColumns(tt.filter)
+		a.Align()
+
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() with VerbatimNoSep %v and filter %v = %q; want %q", tt.verbatim, tt.filter, got, tt.expected)
+		}
+	}
+}
+
+var columnsCases = []struct {
+	input    string
+	columns  int
+	expected string
+}{
+	{"10:01,INFO,started\n10:02,WARN,disk, 90% full\n10:03", 3, "10:01 , INFO , started\n10:02 , WARN , disk, 90% full\n10:03 ,      , \n"},
+	{"a,b,c,d\ne", 2, "a , b,c,d\ne , \n"},
+	{"a,b,c,d\ne", 1, "a,b,c,d\ne\n"},
+}
+
+// TestColumns
+func TestColumns(t *testing.T) {
+	for _, tt := range columnsCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader(tt.input), out, comma, TextQualifier{})
+		a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1, Columns: tt.columns})
+		a.Align()
+
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() of %q with Columns %d = %q; want %q", tt.input, tt.columns, got, tt.expected)
+		}
+	}
+
+	out := &bytes.Buffer{}
+	a := NewAlign(strings.NewReader("a,b,c,d\ne"), out, comma, TextQualifier{})
+	a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1, Columns: 2, CSV: true})
+	a.Align()
+
+	if got, expected := out.String(), "a , b,c,d\ne , \n"; got != expected {
+		t.Fatalf("Align() with Columns and CSV = %q; want %q", got, expected)
+	}
+}
+
+var columnDecorationCases = []struct {
+	prefix   map[int]string
+	suffix   map[int]string
+	expected string
+}{
+	{nil, nil, "apple , 3   , 1.5 \npear  , 120 ,     \n"},
+	{map[int]string{2: "$"}, nil, "apple , $3   , 1.5 \npear  , $120 ,     \n"},
+	{map[int]string{1: "("}, map[int]string{1: ")", 3: " kg"}, "(apple) , 3   , 1.5 kg \n(pear)  , 120 ,        \n"},
+}
+
+// TestColumnDecoration
+func TestColumnDecoration(t *testing.T) {
+	for _, tt := range columnDecorationCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader("apple,3,1.5\npear,120,"), out, comma, TextQualifier{})
+		a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1, ColumnPrefix: tt.prefix, ColumnSuffix: tt.suffix})
+		a.Align()
+
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() with ColumnPrefix %v and ColumnSuffix %v = %q; want %q", tt.prefix, tt.suffix, got, tt.expected)
+		}
+	}
+}
+
+var signColumnCases = []struct {
+	po       PaddingOpts
+	expected string
+}{
+	{PaddingOpts{Justification: JustifyRight, Pad: 1}, "item , amount \n   a ,     -5 \n   b ,    120 \n   c ,   -7.5 \n"},
+	{PaddingOpts{Justification: JustifyRight, Pad: 1, SignColumn: true}, "item , amount \n   a , -    5 \n   b ,    120 \n   c , -  7.5 \n"},
+	{PaddingOpts{Justification: JustifyLeft, Pad: 1, SignColumn: true}, "item , amount \na    , -5     \nb    ,  120   \nc    , -7.5   \n"},
+}
+
+// TestSignColumn
+func TestSignColumn(t *testing.T) {
+	for _, tt := range signColumnCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader("item,amount\na,-5\nb,120\nc,-7.5"), out, comma, TextQualifier{})
+		a.UpdatePadding(tt.po)
+		a.Align()
+
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() with SignColumn %v and justification %d = %q; want %q", tt.po.SignColumn, tt.po.Justification, got, tt.expected)
+		}
+	}
+}
+
+// TestSetLineTransform
+func TestSetLineTransform(t *testing.T) {
+	out := &bytes.Buffer{}
+	a := NewAlign(strings.NewReader("a,bb\n\nccc,d"), out, comma, TextQualifier{})
+	a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1, EmptyLines: EmptyLineVerbatim})
+	a.SetLineTransform(func(row int, line []byte) []byte {
+		return append([]byte(strconv.Itoa(row)+"|"), line...)
+	})
+	a.Align()
+
+	expected := "1|a   , bb \n2|\n3|ccc , d  \n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Align() with SetLineTransform = %q; want %q", got, expected)
+	}
+
+	a = NewAlign(strings.NewReader("a,bb\nccc,d"), &bytes.Buffer{}, comma, TextQualifier{})
+	a.SetLineTransform(func(row int, line []byte) []byte {
+		return bytes.ToUpper(line)
+	})
+	got, err := io.ReadAll(a.Reader())
+	if err != nil {
+		t.Fatalf("Reader() with SetLineTransform returned %v", err)
+	}
+	if expected := "A   , BB \nCCC , D  \n"; string(got) != expected {
+		t.Fatalf("Reader() with SetLineTransform = %q; want %q", got, expected)
+	}
+}
+
+var leadingSepCases = []struct {
+	input    string
+	po       PaddingOpts
+	expected string
+}{
+	{",a,b\nxx,y,z", PaddingOpts{Justification: JustifyLeft}, "  ,a,b\nxx,y,z\n"},
+	{",a,b\nxx,y,z", PaddingOpts{Justification: JustifyLeft, Pad: 1}, "   , a , b \nxx , y , z \n"},
+	{",a,b\nxx,y,z", PaddingOpts{Justification: JustifyLeft, Pad: 2}, "    ,  a  ,  b  \nxx  ,  y  ,  z  \n"},
+	{",a,b\nxx,y,z", PaddingOpts{Justification: JustifyLeft, PadRight: 2}, "  ,  a,  b\nxx,  y,  z\n"},
+	{",a,b\nxx,y,z", PaddingOpts{Justification: JustifyRight, Pad: 1}, "   , a , b \nxx , y , z \n"},
+	{",a\n,bb", PaddingOpts{Justification: JustifyLeft, Pad: 1}, " , a  \n , bb \n"},
+	{" ,a\nxx,y", PaddingOpts{Justification: JustifyLeft}, "  ,a\nxx,y\n"},
+	{" ,a\nxx,y", PaddingOpts{Justification: JustifyLeft, Pad: 1}, "   , a \nxx , y \n"},
+	{" ,a\nxx,y", PaddingOpts{Justification: JustifyLeft, Pad: 1, TrimLeft: true}, "   , a \nxx , y \n"},
+	{" ,a\n ,b", PaddingOpts{Justification: JustifyLeft, Pad: 1, ReAlign: true}, " , a \n , b \n"},
+}
+
+// TestLeadingSeparator
+func TestLeadingSeparator(t *testing.T) {
+	for _, tt := range leadingSepCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader(tt.input), out, comma, TextQualifier{})
+		a.UpdatePadding(tt.po)
+		a.Align()
+
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() of %q with %+v = %q; want %q", tt.input, tt.po, got, tt.expected)
+		}
+	}
+}
+
+// TestClone
+func TestClone(t *testing.T) {
+	a := NewAlign(strings.NewReader("unused"), &bytes.Buffer{}, comma, TextQualifier{})
+	a.OutputSep("|")
+	a.UpdatePadding(PaddingOpts{
+		Justification:  JustifyLeft,
+		Pad:            1,
+		ColumnOverride: map[int]Justification{2: JustifyRight},
+	})
+	a.FilterColumns([]int{1, 2})
+
+	input := "a,bb,x\nccc,d,y"
+	outs := make([]*bytes.Buffer, 3)
+	done := make(chan struct{})
+	for i := range outs {
+		outs[i] = &bytes.Buffer{}
+		c := a.Clone(strings.NewReader(input), outs[i])
+		if i == 1 {
+			c.padOpts.ColumnOverride[2] = JustifyLeft
+			c.filter[1] = 3
+		}
+		go func() {
+			c.Align()
+			done <- struct{}{}
+		}()
+	}
+	for range outs {
+		<-done
+	}
+
+	expected := []string{
+		"a   | bb \nccc |  d \n",
+		"a   | x \nccc | y \n",
+		"a   | bb \nccc |  d \n",
+	}
+	for i, out := range outs {
+		if got := out.String(); got != expected[i] {
+			t.Fatalf("Align() of clone %d = %q; want %q", i, got, expected[i])
+		}
+	}
+	if a.padOpts.ColumnOverride[2] != JustifyRight || a.filter[1] != 2 {
+		t.Fatalf("Clone() shares configuration with the original: %v, %v", a.padOpts.ColumnOverride, a.filter)
+	}
+
+	a = NewAlign(strings.NewReader("#align: sep=| pad=2 just=right\na|bb\nccc|d"), &bytes.Buffer{}, comma, TextQualifier{})
+	a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1, Directive: true})
+	a.Align()
+	out := &bytes.Buffer{}
+	a.Clone(strings.NewReader(input), out).Align()
+	if want := "a   , bb , x \nccc , d  , y \n"; out.String() != want {
+		t.Fatalf("Align() of a clone after Align() = %q; want %q", out.String(), want)
+	}
+}
+
+// TestSeparatorColumnComments
+func TestSeparatorColumnComments(t *testing.T) {
+	input := "# settings\nname = \"a#b\" # the name\n  port = 8080 # default\n\t# indented comment\nverbose = true"
+	out := &bytes.Buffer{}
+	a := NewAlign(strings.NewReader(input), out, "#", TextQualifier{})
+	a.UpdatePadding(PaddingOpts{Pad: 2, SeparatorColumn: true})
+	a.SkipRegions([]SkipRegion{{Open: `"`, Close: `"`}})
+	a.Align()
+
+	expected := "# settings\nname = \"a#b\"   # the name\n  port = 8080  # default\n\t# indented comment\nverbose = true\n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Align() with SeparatorColumn of trailing comments = %q; want %q", got, expected)
+	}
+}
+
+// limitWriter accepts n bytes, after which every Write fails with err.
+type limitWriter struct {
+	bytes.Buffer
+	n   int
+	err error
+}
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		n, _ := w.Buffer.Write(p[:w.n])
+		w.n = 0
+		return n, w.err
+	}
+	w.n -= len(p)
+	return w.Buffer.Write(p)
+}
+
+var writeErrorCases = []struct {
+	limit int
+	po    PaddingOpts
+	err   error
+}{
+	{0, PaddingOpts{Justification: JustifyLeft, Pad: 1}, io.ErrShortWrite},
+	{10, PaddingOpts{Justification: JustifyLeft, Pad: 1, FlushEvery: 1}, io.ErrShortWrite},
+	{5, PaddingOpts{Justification: JustifyLeft, Pad: 1, Compact: true}, io.ErrShortWrite},
+	{1 << 20, PaddingOpts{Justification: JustifyLeft, Pad: 1}, nil},
+}
+
+// TestWriteError
+func TestWriteError(t *testing.T) {
+	input := strings.Repeat("a,bb,ccc\n", 1000)
+	for _, tt := range writeErrorCases {
+		w := &limitWriter{n: tt.limit, err: io.ErrShortWrite}
+		a := NewAlign(strings.NewReader(input), w, comma, TextQualifier{})
+		a.UpdatePadding(tt.po)
+
+		if err := a.Align(); err != tt.err {
+			t.Fatalf("Align() to a writer limited to %d bytes returned %v; want %v", tt.limit, err, tt.err)
+		}
+		if w.Len() > tt.limit {
+			t.Fatalf("Align() wrote %d bytes to a writer limited to %d", w.Len(), tt.limit)
+		}
+	}
+}
+
+var parseJustificationCases = []struct {
+	input    string
+	expected Justification
+	valid    bool
+}{
+	{"left", JustifyLeft, true},
+	{"right", JustifyRight, true},
+	{"center", JustifyCenter, true},
+	{"Center", JustifyCenter, true},
+	{"r", JustifyRight, true},
+	{"middle", 0, false},
+	{"", 0, false},
+}
+
+// TestParseJustification
+func TestParseJustification(t *testing.T) {
+	for _, tt := range parseJustificationCases {
+		got, err := ParseJustification(tt.input)
+		if (err == nil) != tt.valid || got != tt.expected {
+			t.Fatalf("ParseJustification(%q) = %v, %v; want %v, valid %v", tt.input, got, err, tt.expected, tt.valid)
+		}
+	}
+
+	for _, j := range []Justification{JustifyLeft, JustifyRight, JustifyCenter} {
+		if got, err := ParseJustification(j.String()); err != nil || got != j {
+			t.Fatalf("ParseJustification(%q) = %v, %v; want %v", j.String(), got, err, j)
+		}
+	}
+	if got := Justification(9).String(); got != "Justification(9)" {
+		t.Fatalf("Justification(9).String() = %q; want %q", got, "Justification(9)")
+	}
+}
+
+// TestPaddingOptsJSON
+func TestPaddingOptsJSON(t *testing.T) {
+	po := PaddingOpts{
+		Justification:  JustifyRight,
+		ColumnOverride: map[int]Justification{1: JustifyLeft, 3: JustifyCenter},
+		Pad:            2,
+		MinColWidth:    map[int]int{2: 8},
+		HashFunc:       strings.ToUpper,
+	}
+	b, err := json.Marshal(po)
+	if err != nil {
+		t.Fatalf("json.Marshal(PaddingOpts) returned %v", err)
+	}
+	if !bytes.Contains(b, []byte(`"Justification":"right","ColumnOverride":{"1":"left","3":"center"}`)) {
+		t.Fatalf("json.Marshal(PaddingOpts) = %s; want justifications by name", b)
+	}
+
+	var got PaddingOpts
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("json.Unmarshal(%s) returned %v", b, err)
+	}
+	po.HashFunc = nil
+	if !reflect.DeepEqual(got, po) {
+		t.Fatalf("json.Unmarshal(%s) = %+v; want %+v", b, got, po)
+	}
+
+	if err := json.Unmarshal([]byte(`{"Justification":"middle"}`), &got); err == nil {
+		t.Fatalf("json.Unmarshal() of an invalid justification returned no error")
+	}
+
+	qu := TextQualifier{On: true, Qualifier: `"`, Open: '«', Close: '»'}
+	b, err = json.Marshal(qu)
+	if err != nil {
+		t.Fatalf("json.Marshal(TextQualifier) returned %v", err)
+	}
+	if want := `{"On":true,"Qualifier":"\"","Open":"«","Close":"»"}`; string(b) != want {
+		t.Fatalf("json.Marshal(TextQualifier) = %s; want %s", b, want)
+	}
+	var gotQu TextQualifier
+	if err := json.Unmarshal(b, &gotQu); err != nil || gotQu != qu {
+		t.Fatalf("json.Unmarshal(%s) = %+v, %v; want %+v", b, gotQu, err, qu)
+	}
+	if err := json.Unmarshal([]byte(`{"On":true,"Open":91,"Close":93}`), &gotQu); err != nil || gotQu.Open != '[' || gotQu.Close != ']' {
+		t.Fatalf("json.Unmarshal() of numeric runes = %+v, %v; want '[' and ']'", gotQu, err)
+	}
+	if err := json.Unmarshal([]byte(`{"Open":"ab"}`), &gotQu); err == nil {
+		t.Fatalf("json.Unmarshal() of a text qualifier rune of 2 characters returned no error")
+	}
+}
+
+// TestLayoutSpecJSON
+func TestLayoutSpecJSON(t *testing.T) {
+	a := NewAlign(strings.NewReader("[a,b],cc\nd,[e,f]"), &bytes.Buffer{}, comma, TextQualifier{On: true, Open: '[', Close: ']'})
+	a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1})
+	a.OutputSep("|")
+	want := &bytes.Buffer{}
+	a.writer = bufio.NewWriter(want)
+	a.Align()
+
+	b, err := json.Marshal(a.LayoutSpec())
+	if err != nil {
+		t.Fatalf("json.Marshal(LayoutSpec) returned %v", err)
+	}
+	if !bytes.Contains(b, []byte(`"Qualifier":{"On":true,"Qualifier":"","Open":"[","Close":"]"}`)) {
+		t.Fatalf("json.Marshal(LayoutSpec) = %s; want the qualifier runes as strings", b)
+	}
+	var spec LayoutSpec
+	if err := json.Unmarshal(b, &spec); err != nil {
+		t.Fatalf("json.Unmarshal(%s) returned %v", b, err)
+	}
+
+	out := &bytes.Buffer{}
+	NewAlignFromSpec(strings.NewReader("[a,b],cc\nd,[e,f]"), out, spec).Align()
+	if want.String() != "[a,b] | cc    \nd     | [e,f] \n" || out.String() != want.String() {
+		t.Fatalf("Align() with the decoded LayoutSpec = %q; want %q", out.String(), want.String())
+	}
+}
+
+var repeatHeaderCases = []struct {
+	every    int
+	po       PaddingOpts
+	rules    []int
+	expected string
+}{
+	{0, PaddingOpts{}, nil, "id , qty \n1  , 10  \n2  , 20  \n3  , 30  \n"},
+	{2, PaddingOpts{}, nil, "id , qty \n1  , 10  \n2  , 20  \nid , qty \n3  , 30  \n"},
+	{1, PaddingOpts{}, []int{1}, "id , qty \n---------\n1  , 10  \nid , qty \n---------\n2  , 20  \nid , qty \n---------\n3  , 30  \n"},
+	{3, PaddingOpts{}, nil, "id , qty \n1  , 10  \n2  , 20  \n3  , 30  \n"},
+	{1, PaddingOpts{HeaderLines: 2}, nil, "id , qty \n1  , 10  \n2  , 20  \nid , qty \n1  , 10  \n3  , 30  \n"},
+}
+
+// TestRepeatHeaderEvery
+func TestRepeatHeaderEvery(t *testing.T) {
+	for _, tt := range repeatHeaderCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader("id,qty\n1,10\n2,20\n3,30"), out, comma, TextQualifier{})
+		tt.po.Justification, tt.po.Pad, tt.po.RepeatHeaderEvery = JustifyLeft, 1, tt.every
+		a.UpdatePadding(tt.po)
+		a.RuleAfter(tt.rules)
+		a.Align()
+
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() with RepeatHeaderEvery %d and HeaderLines %d = %q; want %q", tt.every, tt.po.HeaderLines, got, tt.expected)
+		}
+	}
+}
+
+var wideSepCases = []PaddingOpts{
+	{Justification: JustifyLeft, Pad: 1},
+	{Justification: JustifyRight, Pad: 1, RowNumbers: true},
+	{Justification: JustifyCenter, Pad: 2, SepWidth: 3},
+	{Justification: JustifyLeft, PadLeft: 1, OuterPad: 2},
 }
 
-// TestUpdatePadding
-func TestUpdatePadding(t *testing.T) {
-	for _, tt := range updatePaddingCases {
-		a := &Align{}
-		a.UpdatePadding(PaddingOpts{Justification: tt.input})
+// TestWideSeparator
+func TestWideSeparator(t *testing.T) {
+	input := "a→世界→c\nlong→x→ｙｙ\n→→"
+	for _, po := range wideSepCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader(input), out, "→", TextQualifier{})
+		a.UpdatePadding(po)
+		a.Align()
 
-		if a.padOpts.Justification != tt.expected {
-			t.Fatalf("updatePadding(%v) = %v; want %v", tt.input, a.padOpts.Justification, tt.expected)
+		var sepColumns string
+		for n, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+			if w := displayWidth(line); w != a.TotalWidth() {
+				t.Fatalf("line %d of Align() with %+v is %d wide; TotalWidth() = %d", n+1, po, w, a.TotalWidth())
+			}
+			var columns []string
+			for i := range line {
+				if strings.HasPrefix(line[i:], "→") {
+					columns = append(columns, strconv.Itoa(displayWidth(line[:i])))
+				}
+			}
+			if n == 0 {
+				sepColumns = strings.Join(columns, ",")
+			} else if got := strings.Join(columns, ","); got != sepColumns {
+				t.Fatalf("separators of line %d of Align() with %+v are at columns %s; want %s", n+1, po, got, sepColumns)
+			}
 		}
 	}
+
+	out := &bytes.Buffer{}
+	a := NewAlign(strings.NewReader("a→1\n世界世界→2\nｂ → 3"), out, "→", TextQualifier{})
+	a.UpdatePadding(PaddingOpts{Pad: 1, SeparatorColumn: true})
+	a.Align()
+
+	expected := "a        →1\n世界世界 →2\nｂ       → 3\n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Align() with SeparatorColumn and a wide separator = %q; want %q", got, expected)
+	}
 }
 
-// TestColumnSize
-func TestColumnSize(t *testing.T) {
-	for _, tt := range columnSizeCases {
-		a := &Align{columnCounts: tt.counts}
+// TestAlignMatching
+func TestAlignMatching(t *testing.T) {
+	input := "starting up\n| id | name |\n| 1 | alice |\nwarning: a | b\n| 22 | bo |\ndone"
+	out := &bytes.Buffer{}
+	a := NewAlign(strings.NewReader(input), out, "|", TextQualifier{})
+	a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, TrimLeft: true, TrimRight: true, Pad: 1})
+	a.AlignMatching(regexp.MustCompile(`^\|.*\|$`))
+	a.Align()
 
-		got := a.columnSize(tt.cNum)
-		if got != tt.expected {
-			t.Fatalf("columnSize(%v) = %v; want %v", tt.cNum, got, tt.expected)
+	expected := "starting up\n | id | name  |  \n | 1  | alice |  \nwarning: a | b\n | 22 | bo    |  \ndone\n"
+	if got := out.String(); got != expected {
+		t.Fatalf("Align() with AlignMatching = %q; want %q", got, expected)
+	}
+}
+
+var emptyValueCases = []struct {
+	po       PaddingOpts
+	expected string
+}{
+	{PaddingOpts{}, "id , name , note \n1  ,      ,      \n2  , bo   ,      \n"},
+	{PaddingOpts{EmptyValue: "-"}, "id , name , note \n1  , -    ,      \n2  , bo   , -    \n"},
+	{PaddingOpts{EmptyValue: "NULL", EmptyBlank: true}, "id , name , note \n1  , NULL , NULL \n2  , bo   , NULL \n"},
+	{PaddingOpts{EmptyValue: "(none)", EmptyBlank: true}, "id , name   , note   \n1  , (none) , (none) \n2  , bo     , (none) \n"},
+}
+
+// TestEmptyValue
+func TestEmptyValue(t *testing.T) {
+	for _, tt := range emptyValueCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader("id,name,note\n1,,  \n2,bo,"), out, comma, TextQualifier{})
+		tt.po.Justification, tt.po.Pad = JustifyLeft, 1
+		a.UpdatePadding(tt.po)
+		a.Align()
+
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() with EmptyValue %q and EmptyBlank %v = %q; want %q", tt.po.EmptyValue, tt.po.EmptyBlank, got, tt.expected)
 		}
 	}
 }
 
-// TestOutputSep
-func TestOutputSep(t *testing.T) {
-	for _, tt := range ouputSepCases {
-		a := &Align{}
-		a.OutputSep(tt.input)
+// TestAlignFile
+func TestAlignFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.csv")
+	if err := os.WriteFile(path, []byte("a,bb\nccc,d\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
 
-		if a.sepOut != tt.expected {
-			t.Fatalf("outputSep(%v) = %v; want %v", tt.input, a.sepOut, tt.expected)
+	if err := AlignFile(path, comma, TextQualifier{}, PaddingOpts{Justification: JustifyLeft, Pad: 1}); err != nil {
+		t.Fatalf("AlignFile() returned %v", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "a   , bb \nccc , d  \n"; string(got) != expected {
+		t.Fatalf("AlignFile() wrote %q; want %q", got, expected)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0640 {
+		t.Fatalf("AlignFile() left the file with mode %v, %v; want %v", info.Mode().Perm(), err, os.FileMode(0640))
+	}
+
+	// a failed alignment leaves the file unchanged
+	if err := AlignFile(path, "::", TextQualifier{}, PaddingOpts{Justification: JustifyLeft, CSV: true}); err == nil {
+		t.Fatalf("AlignFile() with an invalid CSV separator returned no error")
+	}
+	if again, _ := os.ReadFile(path); !bytes.Equal(again, got) {
+		t.Fatalf("AlignFile() that failed changed the file to %q", again)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("AlignFile() left %d files in the directory; want 1", len(entries))
+	}
+
+	if err := AlignFile(filepath.Join(dir, "missing"), comma, TextQualifier{}, PaddingOpts{}); err == nil {
+		t.Fatalf("AlignFile() of a missing file returned no error")
+	}
+}
+
+var trimTrailingJustificationCases = []struct {
+	po       PaddingOpts
+	expected string
+}{
+	{PaddingOpts{Justification: JustifyLeft, Pad: 1}, "a   , bb ,  x\nccc , d  , yyy \nee\n"},
+	{PaddingOpts{Justification: JustifyRight, Pad: 1}, "  a , bb ,    x\nccc ,  d , yyy \n ee\n"},
+	{PaddingOpts{Justification: JustifyCenter, Pad: 1}, " a  , bb ,   x\nccc ,  d , yyy \n ee\n"},
+	{PaddingOpts{Justification: JustifyCenter, Pad: 2, CenterBias: CenterBiasLeft}, " a   ,  bb  ,    x\nccc  ,  d   ,  yyy \nee\n"},
+	{PaddingOpts{Justification: JustifyRight, PadLeft: 2, PadRight: 1}, "  a  , bb  ,    x\nccc  ,  d  , yyy \n ee\n"},
+	{PaddingOpts{Justification: JustifyRight, Pad: 1, OuterPad: 2}, "    a , bb ,    x\n  ccc ,  d , yyy \n   ee\n"},
+	{PaddingOpts{Justification: JustifyCenter, Pad: 1, RowNumbers: true}, "1 ,  a  , bb ,   x\n2 , ccc ,  d , yyy \n3 ,  ee\n"},
+	{PaddingOpts{Justification: JustifyRight, Pad: 1, GroupSep: "|"}, "  a , bb ,    x\nccc ,  d , yyy \n ee\n"},
+}
+
+// TestTrimTrailingSpaceJustification
+func TestTrimTrailingSpaceJustification(t *testing.T) {
+	for _, tt := range trimTrailingJustificationCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader("a,bb, x\nccc,d,yyy \nee"), out, comma, TextQualifier{})
+		tt.po.TrimTrailingSpace = true
+		a.UpdatePadding(tt.po)
+		a.Align()
+
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() with TrimTrailingSpace and %+v = %q; want %q", tt.po, got, tt.expected)
 		}
 	}
 }
 
-// TestColumnFilter
-func TestColumnFilter(t *testing.T) {
-	for _, tt := range exportCases {
-		a := NewAlign(tt.input, tt.output, comma, TextQualifier{})
-		a.UpdatePadding(PaddingOpts{ColumnOverride: tt.overrides, Pad: tt.pad})
-		a.FilterColumns(tt.outColumns)
+var rowColumnCountsCases = []struct {
+	input    string
+	po       PaddingOpts
+	expected []int
+}{
+	{"a,b,c\nd,e\n\nf,g,h,i", PaddingOpts{}, []int{3, 2, 0, 4}},
+	{"a,b,c\nd,e\n\nf,g,h,i", PaddingOpts{EmptyLines: EmptyLineVerbatim}, []int{3, 2, 0, 4}},
+	{"a,b,c\nd,e\n\nf,g,h,i", PaddingOpts{EmptyLines: EmptyLineSkip}, []int{3, 2, 4}},
+	{"a,\"b,c\"\nd", PaddingOpts{}, []int{2, 1}},
+	{"a,b,c\nd,e", PaddingOpts{Columns: 2}, []int{2, 2}},
+}
 
+// TestRowColumnCounts
+func TestRowColumnCounts(t *testing.T) {
+	for _, tt := range rowColumnCountsCases {
+		a := NewAlign(strings.NewReader(tt.input), &bytes.Buffer{}, comma, TextQualifier{On: true, Qualifier: `"`})
+		tt.po.Justification = JustifyLeft
+		a.UpdatePadding(tt.po)
 		a.Align()
+
+		if got := a.RowColumnCounts(); !reflect.DeepEqual(got, tt.expected) {
+			t.Fatalf("RowColumnCounts() of %q = %v; want %v", tt.input, got, tt.expected)
+		}
 	}
 }
 
-// TestSplit
-func TestSplit(t *testing.T) {
-	for _, tt := range qualifiedSplitCases {
-		a := NewAlign(strings.NewReader(tt.input), os.Stdout, comma, TextQualifier{On: true, Qualifier: "\""})
-		got := a.splitWithQual(tt.input, tt.sep, tt.qual)
+var minGapCases = []struct {
+	po       PaddingOpts
+	sepOut   string
+	expected string
+}{
+	{PaddingOpts{Justification: JustifyLeft}, "", "abcdxy\nefgh\n"},
+	{PaddingOpts{Justification: JustifyLeft, MinGap: 1}, "", "abcd xy \nefgh \n"},
+	{PaddingOpts{Justification: JustifyRight, MinGap: 2}, "|", "abcd  |xy  \nefgh  \n"},
+	{PaddingOpts{Justification: JustifyLeft, PadRight: 1, MinGap: 2}, "|", "abcd | xy \nefgh \n"},
+	{PaddingOpts{Justification: JustifyLeft, Pad: 2, MinGap: 1}, "|", "abcd  |  xy  \nefgh  \n"},
+}
 
-		if len(got) != tt.expected {
-			t.Fatalf("splitWithQual(%v, %v, %v) = %v; want %v", tt.input, tt.sep, tt.qual, len(got), tt.expected)
+// TestMinGap
+func TestMinGap(t *testing.T) {
+	for _, tt := range minGapCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader("abcd,xy\nefgh"), out, comma, TextQualifier{})
+		a.OutputSep(tt.sepOut)
+		a.UpdatePadding(tt.po)
+		a.Align()
+
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() with MinGap %d, Pad %d and output separator %q = %q; want %q", tt.po.MinGap, tt.po.Pad, tt.sepOut, got, tt.expected)
 		}
 	}
 }
 
-// TestPad
-func TestPad(t *testing.T) {
-	for _, tt := range paddingCases {
-		padLen := countPadding(tt.input, tt.columnCount)
-		got := applyPadding(tt.pad, tt.input, " ", 1, padLen, tt.po.Justification)
+var pivotColumnCases = []struct {
+	pivot    int
+	override map[int]Justification
+	expected string
+}{
+	{0, nil, "a   , bb , c    , d  \neee , f  , gggg , hh \n"},
+	{2, nil, "  a , bb , c    , d  \neee ,  f , gggg , hh \n"},
+	{1, nil, " a  , bb , c    , d  \neee , f  , gggg , hh \n"},
+	{3, map[int]Justification{1: JustifyLeft, 3: JustifyRight}, "a   , bb ,    c , d  \neee ,  f , gggg , hh \n"},
+}
 
-		if len(got) != tt.expected {
-			t.Fatalf("pad(%v) =%v; want %v", tt.input, got, tt.expected)
+// TestPivotColumn
+func TestPivotColumn(t *testing.T) {
+	for _, tt := range pivotColumnCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader("a,bb,c,d\neee,f,gggg,hh"), out, comma, TextQualifier{})
+		a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1, PivotColumn: tt.pivot, ColumnOverride: tt.override})
+		a.Align()
+
+		if got := out.String(); got != tt.expected {
+			t.Fatalf("Align() with PivotColumn %d and ColumnOverride %v = %q; want %q", tt.pivot, tt.override, got, tt.expected)
 		}
 	}
 }
 
-// TestColumnCounts
-func TestColumnCounts(t *testing.T) {
-	for _, tt := range columnCountCases {
-		a := NewAlign(strings.NewReader(tt.input), os.Stdout, tt.sep, TextQualifier{On: tt.isQual, Qualifier: tt.qual})
-		a.columnLength()
-		for i := range tt.counts {
-			if a.columnSize(i) != tt.counts[i] {
-				t.Fatalf("Count for column %v = %v, want %v", i, a.columnSize(i), tt.counts[i])
-			}
+var preserveInputSpacingCases = []struct {
+	input    string
+	pad      int
+	expected string
+}{
+	{"a,b\ncc,d", 1, "a  , b \ncc , d \n"},
+	{"a  ,  b\nccc,d", 1, "a    ,  b  \nccc  ,  d  \n"},
+	{"a  ,  b\nccc,d", 3, "a     ,   b   \nccc   ,   d   \n"},
+	{"a ,b\nccc,   d,e", 1, "a   ,   b \nccc ,   d ,   e \n"},
+}
+
+// TestPreserveInputSpacing
+func TestPreserveInputSpacing(t *testing.T) {
+	for _, tt := range preserveInputSpacingCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader(tt.input), out, comma, TextQualifier{})
+		a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: tt.pad, PreserveInputSpacing: true})
+		a.Align()
+
+		if out.String() != tt.expected {
+			t.Fatalf("Align() of %q with Pad %d = %q; want %q", tt.input, tt.pad, out.String(), tt.expected)
 		}
 	}
 }
 
-// TestFieldLenEscaped
-func TestFieldLenEscaped(t *testing.T) {
-	for _, tt := range fieldLenEscapedCases {
-		got := fieldLenEscaped(tt.input, tt.sep, tt.qual)
-		if got != tt.expected {
-			t.Fatalf("FieldLenEscaped(%v) = %v; want %v", tt.input, got, tt.expected)
+var columnWidthPercentCases = []struct {
+	percent  map[int]float64
+	expected string
+}{
+	{map[int]float64{1: 50}, "name            , descr… , id \nalpha           , a rat… , 1  \nb               , short  , 22 \n"},
+	{map[int]float64{2: 20}, "name  , descr… , id \nalpha , a rat… , 1  \nb     , short  , 22 \n"},
+	{map[int]float64{1: 10, 2: 60}, "na… , description        , id \nal… , a rather long des… , 1  \nb   , short              , 22 \n"},
+	{map[int]float64{2: 0, 4: 50}, "name  , description      , id \nalpha , a rather long d… , 1  \nb     , short            , 22 \n"},
+}
+
+// TestColumnWidthPercent
+func TestColumnWidthPercent(t *testing.T) {
+	for _, tt := range columnWidthPercentCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader("name,description,id\nalpha,a rather long description,1\nb,short,22"), out, comma, TextQualifier{})
+		a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1, MaxTotalWidth: 30, ColumnWidthPercent: tt.percent})
+		a.Align()
+
+		if out.String() != tt.expected {
+			t.Fatalf("Align() with ColumnWidthPercent %v = %q; want %q", tt.percent, out.String(), tt.expected)
 		}
 	}
 }
 
-// TestFieldLen
-func TestFieldLen(t *testing.T) {
-	for _, tt := range fieldLenCases {
-		got := fieldLen(tt.input, tt.sep)
-		if got != tt.expected {
-			t.Fatalf("FieldLen(%v) = %v; want %v", tt.input, got, tt.expected)
+var compactifyCases = []struct {
+	input    string
+	expected string
+}{
+	{"a    , bb , c\nlong , d  , e\n", "a,bb,c\nlong,d,e\n"},
+	{"name    , note        , n  \nalpha   , \"x, y\"      , 1  \n\"b, c\"  , plain text  , 22 \n", "name,note,n\nalpha,\"x, y\",1\n\"b, c\",plain text,22\n"},
+	{"  \"a\"  ,\"b , c\"\n", "\"a\",\"b , c\"\n"},
+}
+
+// TestCompactify
+func TestCompactify(t *testing.T) {
+	for _, tt := range compactifyCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader(tt.input), out, comma, TextQualifier{On: true, Qualifier: `"`})
+		a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 2})
+		if err := a.Compactify(); err != nil {
+			t.Fatalf("Compactify() of %q returned %v", tt.input, err)
+		}
+
+		if out.String() != tt.expected {
+			t.Fatalf("Compactify() of %q = %q; want %q", tt.input, out.String(), tt.expected)
+		}
+		if a.padOpts.Pad != 2 || a.padOpts.Compact {
+			t.Fatalf("Compactify() did not restore the PaddingOpts: %+v", a.padOpts)
 		}
 	}
 }
 
-// TestCountPadding
-func TestCountPadding(t *testing.T) {
-	for _, tt := range countPaddingCases {
-		got := countPadding(tt.input, tt.fieldLen)
-		if got != tt.expected {
-			t.Fatalf("countPadding(%v) = %v; want %v", tt.input, got, tt.expected)
+var schemaPreviewCases = []struct {
+	headerLines int
+	expected    string
+}{
+	{0, "id , name          , qty \n-------------------------\n"},
+	{2, "id , name          , qty \n#  , text          , n   \n-------------------------\n"},
+}
+
+// TestSchemaPreview
+func TestSchemaPreview(t *testing.T) {
+	for _, tt := range schemaPreviewCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader("id,name,qty\n#,text,n\n1,a longer name,5\n22,b,100"), out, comma, TextQualifier{})
+		a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1, HeaderLines: tt.headerLines, SchemaPreview: true})
+		a.Align()
+
+		if out.String() != tt.expected {
+			t.Fatalf("Align() with SchemaPreview and HeaderLines %d = %q; want %q", tt.headerLines, out.String(), tt.expected)
+		}
+
+		a = NewAlign(strings.NewReader("id,name,qty\n#,text,n\n1,a longer name,5\n22,b,100"), &bytes.Buffer{}, comma, TextQualifier{})
+		a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1, HeaderLines: tt.headerLines, SchemaPreview: true})
+		got, err := io.ReadAll(a.Reader())
+		if err != nil || string(got) != tt.expected {
+			t.Fatalf("Reader() with SchemaPreview and HeaderLines %d = %q, %v; want %q", tt.headerLines, got, err, tt.expected)
 		}
 	}
 }
 
-// TestGenFieldLen
-func TestGenFieldLen(t *testing.T) {
+var columnDecimalsCases = []struct {
+	po       PaddingOpts
+	expected string
+}{
+	{PaddingOpts{Justification: JustifyRight, Pad: 1, ColumnDecimals: map[int]int{2: 2}},
+		"item ,     price \n   a ,      1.00 \n   b ,      7.00 \n   c ,       n/a \n   d , +12345.68 \n   e ,     -0.00 \n"},
+	{PaddingOpts{Justification: JustifyRight, Pad: 1, ColumnDecimals: map[int]int{2: 2}, GroupThousands: true},
+		"item ,      price \n   a ,       1.00 \n   b ,       7.00 \n   c ,        n/a \n   d , +12,345.68 \n   e ,      -0.00 \n"},
+	{PaddingOpts{Justification: JustifyLeft, ColumnDecimals: map[int]int{1: 1, 2: 0}},
+		"item,price \na   ,1     \nb   ,7     \nc   ,n/a   \nd   ,+12346\ne   ,-0    \n"},
+}
 
-	for _, tt := range genFieldLenCases {
-		got := genFieldLen(tt.input, tt.sep, tt.qual)
+// TestColumnDecimals
+func TestColumnDecimals(t *testing.T) {
+	for _, tt := range columnDecimalsCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader("item,price\na,1.005\nb,7\nc,n/a\nd,+12345.678\ne,-0.001"), out, comma, TextQualifier{})
+		a.UpdatePadding(tt.po)
+		a.Align()
 
-		if got != tt.expected {
-			t.Fatalf("genFieldLen(%v, %v, %v) = %v; want %v", tt.input, tt.sep, tt.qual, got, tt.expected)
+		if out.String() != tt.expected {
+			t.Fatalf("Align() with ColumnDecimals %v = %q; want %q", tt.po.ColumnDecimals, out.String(), tt.expected)
 		}
 	}
 }
 
-func TestGenFieldLen_Failure(t *testing.T) {
-	got := genFieldLen("", "", "")
-	expected := 0
-	if got != expected {
-		t.Fatalf(`genFieldLen("", "", "") = %v; want %v`, got, expected)
+var mixedQualifierCases = []struct {
+	input    string
+	qual     string
+	expected []string
+}{
+	{`a,"b,c",d`, `"`, []string{"a", `"b,c"`, "d"}},
+	{`1,x"y,"z,w"`, `"`, []string{"1", `x"y`, `"z,w"`}},
+	{`"a""b,c",d,`, `"`, []string{`"a""b,c"`, "d", ""}},
+	{`,"",e`, `"`, []string{"", `""`, "e"}},
+	{`"a",b,`, `"`, []string{`"a"`, "b", ""}},
+	{`''x,y'',z,''`, `''`, []string{"''x,y''", "z", "''"}},
+	{`x,''y,z'',`, `''`, []string{"x", "''y,z''", ""}},
+}
+
+// TestMixedQualifiedFields
+func TestMixedQualifiedFields(t *testing.T) {
+	for _, tt := range mixedQualifierCases {
+		a := NewAlign(strings.NewReader(""), &bytes.Buffer{}, comma, TextQualifier{On: true, Qualifier: tt.qual})
+
+		if got := a.Split(tt.input); !reflect.DeepEqual(got, tt.expected) {
+			t.Fatalf("Split(%q) with qualifier %q = %q; want %q", tt.input, tt.qual, got, tt.expected)
+		}
 	}
 }
 
-// BenchmarkColumnCounts
-func BenchmarkColumnCounts(b *testing.B) {
-	input := `First,Middle,Last,Email,Region,City,Zip,Full_Name,First,Middle,Last,Email,Region,City,Zip,Full_Name,First,Middle,Last,Email,Region,City,Zip,Full_Name
-Karleigh,Destiny,Dean,nunc.In@lorem.edu,Stockholms län,Märsta,9038,Shaine Reilly,Karleigh,Destiny,Dean,nunc.In@lorem.edu,Stockholms län,Märsta,9038,Shaine Reilly,Karleigh,Destiny,Dean,nunc.In@lorem.edu,Stockholms län,Märsta,9038,Shaine Reilly        
-Alisa,Walker,Armand,Sed@Nuncmauriselit.com,Himachal Pradesh,Shimla,MZ0 4QS,Olivia Velez ,Alisa,Walker,Armand,Sed@Nuncmauriselit.com,Himachal Pradesh,Shimla,MZ0 4QS,Olivia Velez ,Alisa,Walker,Armand,Sed@Nuncmauriselit.com,Himachal Pradesh,Shimla,MZ0 4QS,Olivia Velez
-Karleigh,Destiny,Dean,nunc.In@lorem.edu,Stockholms län,Märsta,9038,Shaine Reilly,Karleigh,Destiny,Dean,nunc.In@lorem.edu,Stockholms län,Märsta,9038,Shaine Reilly,Karleigh,Destiny,Dean,nunc.In@lorem.edu,Stockholms län,Märsta,9038,Shaine Reilly        
-Alisa,Walker,Armand,Sed@Nuncmauriselit.com,Himachal Pradesh,Shimla,MZ0 4QS,Olivia Velez ,Alisa,Walker,Armand,Sed@Nuncmauriselit.com,Himachal Pradesh,Shimla,MZ0 4QS,Olivia Velez ,Alisa,Walker,Armand,Sed@Nuncmauriselit.com,Himachal Pradesh,Shimla,MZ0 4QS,Olivia Velez
-Karleigh,Destiny,Dean,nunc.In@lorem.edu,Stockholms län,Märsta,9038,Shaine Reilly,Karleigh,Destiny,Dean,nunc.In@lorem.edu,Stockholms län,Märsta,9038,Shaine Reilly,Karleigh,Destiny,Dean,nunc.In@lorem.edu,Stockholms län,Märsta,9038,Shaine Reilly
-Alisa,Walker,Armand,Sed@Nuncmauriselit.com,Himachal Pradesh,Shimla,MZ0 4QS,Olivia Velez ,Alisa,Walker,Armand,Sed@Nuncmauriselit.com,Himachal Pradesh,Shimla,MZ0 4QS,Olivia Velez ,Alisa,Walker,Armand,Sed@Nuncmauriselit.com,Himachal Pradesh,Shimla,MZ0 4QS,Olivia Velez
-`
+// diffMarker splits the marker of a unified diff line from the rest of the line.
+func diffMarker(line string) (string, string) {
+	if line == "" {
+		return "", ""
+	}
+	return line[:1], line[1:]
+}
 
-	a := NewAlign(strings.NewReader(input), &bytes.Buffer{}, comma, TextQualifier{On: false})
+var preservePrefixCases = []struct {
+	input    string
+	po       PaddingOpts
+	expected string
+}{
+	{" a = 1\n-bbb = 2\n+cc = 33\n", PaddingOpts{Justification: JustifyLeft, Pad: 1, ReAlign: true}, " a   = 1  \n-bbb = 2  \n+cc  = 33 \n"},
+	{" a = 1\n-bbb = 2\n+cc = 33\n", PaddingOpts{Justification: JustifyRight, Pad: 1, ReAlign: true, Indent: "  "}, "     a =  1 \n-  bbb =  2 \n+   cc = 33 \n"},
+	{"+a=1\n note\n-bbb=2\n", PaddingOpts{Justification: JustifyLeft, KeyValue: true}, "+a  =1\n note\n-bbb=2\n"},
+	{" a   = 1\n-bbb = 2\n", PaddingOpts{Justification: JustifyLeft, Compact: true}, " a=1\n-bbb=2\n"},
+	{"+a=b\n+ccc=d", PaddingOpts{Justification: JustifyLeft, Pad: 1, CSV: true}, "+a   = b \n+ccc = d \n"},
+}
 
-	b.ReportAllocs()
-	b.ResetTimer()
+// TestPreservePrefix
+func TestPreservePrefix(t *testing.T) {
+	for _, tt := range preservePrefixCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader(tt.input), out, "=", TextQualifier{})
+		tt.po.PreservePrefix = diffMarker
+		a.UpdatePadding(tt.po)
+		a.Align()
 
-	for i := 0; i < b.N; i++ {
-		a.columnLength()
+		if out.String() != tt.expected {
+			t.Fatalf("Align() of %q with PreservePrefix = %q; want %q", tt.input, out.String(), tt.expected)
+		}
 	}
 }
 
-// BenchmarkSplitWithQual
-func BenchmarkSplitWithQual(b *testing.B) {
-	input := "First,\"Middle, name\",Last,Email,Region,City,Zip,Full_Name"
+var maxColumnsCases = []struct {
+	input    string
+	po       PaddingOpts
+	expected string
+	err      bool
+}{
+	{"a,b,c,d\nee,f", PaddingOpts{MaxColumns: 2}, "a ,b,c,d\nee,f    \n", false},
+	{"a,b,c,d\nee,f", PaddingOpts{MaxColumns: 4}, "a ,b,c,d\nee,f\n", false},
+	{"a,b,c,d\nee,f", PaddingOpts{MaxColumns: 3, MaxSplits: 2}, "a ,b,c,d\nee,f    \n", false},
+	{"a,\"b,c\",d\nee,f", PaddingOpts{MaxColumns: 2, CSV: true}, "a ,b,c,d\nee,f    \n", false},
+	{"a,b,c,d\nee,f", PaddingOpts{MaxColumns: 3, MaxColumnsError: true}, "", true},
+	{"a,b,c\nee,f", PaddingOpts{MaxColumns: 3, MaxColumnsError: true}, "a ,b,c\nee,f\n", false},
+}
 
-	a := NewAlign(strings.NewReader(input), &bytes.Buffer{}, comma, TextQualifier{On: true, Qualifier: "\""})
+// TestMaxColumns
+func TestMaxColumns(t *testing.T) {
+	for _, tt := range maxColumnsCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader(tt.input), out, comma, TextQualifier{})
+		tt.po.Justification = JustifyLeft
+		a.UpdatePadding(tt.po)
+		err := a.Align()
 
-	b.ReportAllocs()
-	b.ResetTimer()
+		if (err != nil) != tt.err {
+			t.Fatalf("Align() of %q with MaxColumns %d returned %v", tt.input, tt.po.MaxColumns, err)
+		}
+		if out.String() != tt.expected {
+			t.Fatalf("Align() of %q with MaxColumns %d = %q; want %q", tt.input, tt.po.MaxColumns, out.String(), tt.expected)
+		}
+	}
+}
 
-	for i := 0; i < b.N; i++ {
-		a.splitWithQual(input, comma, "\"")
+var fillWidthCases = []struct {
+	po       PaddingOpts
+	expected string
+}{
+	{PaddingOpts{Justification: JustifyLeft, Pad: 1, FillWidth: 24}, "id , name  , qty        \n1  , apple , 5          \n22 , pear               \n"},
+	{PaddingOpts{Justification: JustifyRight, Pad: 1, FillWidth: 24}, "id ,  name ,        qty \n 1 , apple ,          5 \n22 ,  pear              \n"},
+	{PaddingOpts{Justification: JustifyLeft, Pad: 1, FillWidth: 24, TrimTrailingSpace: true}, "id , name  , qty        \n1  , apple , 5          \n22 , pear               \n"},
+	{PaddingOpts{Justification: JustifyLeft, Pad: 1, FillWidth: 5}, "id , name  , qty \n1  , apple , 5   \n22 , pear  \n"},
+}
+
+// TestFillWidth
+func TestFillWidth(t *testing.T) {
+	for _, tt := range fillWidthCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader("id,name,qty\n1,apple,5\n22,pear\n"), out, comma, TextQualifier{})
+		a.UpdatePadding(tt.po)
+		a.Align()
+
+		if out.String() != tt.expected {
+			t.Fatalf("Align() with %+v = %q; want %q", tt.po, out.String(), tt.expected)
+		}
 	}
 }
 
-// BenchmarkSplitWithQualNoQual
-func BenchmarkSplitWithQualNoQual(b *testing.B) {
-	input := "First,Middle,Last,Email,Region,City,Zip,Full_Name"
+var flexColumnCases = []struct {
+	fill     int
+	flex     int
+	expected string
+}{
+	{40, 2, "id , description                  , qty \n1  , a rather long description    , 5   \n22 , short                        , 100 \n"},
+	{30, 2, "id , description        , qty \n1  , a rather long des… , 5   \n22 , short              , 100 \n"},
+	{5, 2, "id , … , qty \n1  , … , 5   \n22 , … , 100 \n"},
+	{40, 1, "id    , description               , qty \n1     , a rather long description , 5   \n22    , short                     , 100 \n"},
+	{30, 4, "id , description               , qty \n1  , a rather long description , 5   \n22 , short                     , 100 \n"},
+}
 
-	a := NewAlign(strings.NewReader(input), &bytes.Buffer{}, comma, TextQualifier{On: false})
+// TestFlexColumn
+func TestFlexColumn(t *testing.T) {
+	for _, tt := range flexColumnCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader("id,description,qty\n1,a rather long description,5\n22,short,100\n"), out, comma, TextQualifier{})
+		a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Pad: 1, FillWidth: tt.fill, FlexColumn: tt.flex})
+		a.Align()
 
-	b.ReportAllocs()
-	b.ResetTimer()
+		if out.String() != tt.expected {
+			t.Fatalf("Align() with FillWidth %d and FlexColumn %d = %q; want %q", tt.fill, tt.flex, out.String(), tt.expected)
+		}
+	}
+}
 
-	for i := 0; i < b.N; i++ {
-		a.splitWithQual(input, comma, "\"")
+var transposeCases = []struct {
+	input    string
+	filter   []int
+	expected string
+}{
+	{"id,description,qty\n1,apple,5", nil, "id         : 1\ndescription: apple\nqty        : 5\n"},
+	{"id,description,qty\n1,apple,5\n22,a longer pear,100,extra\n", nil,
+		"id         : 1\ndescription: apple\nqty        : 5\n--------------------------\nid         : 22\ndescription: a longer pear\nqty        : 100\n4          : extra\n"},
+	{"id,description,qty\n1,apple,5\n22,pear,100", []int{1, 3}, "id : 1\nqty: 5\n--------\nid : 22\nqty: 100\n"},
+	{"id,,qty\n1,apple,5", nil, "id : 1\n2  : apple\nqty: 5\n"},
+}
+
+// TestTranspose
+func TestTranspose(t *testing.T) {
+	for _, tt := range transposeCases {
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader(tt.input), out, comma, TextQualifier{})
+		a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Transpose: true})
+		a.FilterColumns(tt.filter)
+		a.Align()
+
+		if out.String() != tt.expected {
+			t.Fatalf("Align() of %q with Transpose = %q; want %q", tt.input, out.String(), tt.expected)
+		}
+
+		a = NewAlign(strings.NewReader(tt.input), &bytes.Buffer{}, comma, TextQualifier{})
+		a.UpdatePadding(PaddingOpts{Justification: JustifyLeft, Transpose: true})
+		a.FilterColumns(tt.filter)
+		got, err := io.ReadAll(a.Reader())
+		if err != nil || string(got) != out.String() {
+			t.Fatalf("Reader() of %q with Transpose = %q, %v; want %q", tt.input, got, err, out.String())
+		}
 	}
 }
 
-func BenchmarkExport(b *testing.B) {
-	input := "First,Middle,Last,Email,Region,City,Zip,Full_Name"
+// stripe wraps the odd rows in brackets.
+func stripe(rowIndex int, line []byte) []byte {
+	if rowIndex%2 == 0 {
+		return line
+	}
+	return []byte("[" + string(line) + "]")
+}
 
-	a := NewAlign(strings.NewReader(input), &bytes.Buffer{}, comma, TextQualifier{On: false})
-	a.columnLength()
+var rowWrapperCases = []struct {
+	input    string
+	po       PaddingOpts
+	rules    []int
+	expected string
+}{
+	{"a,b\ncc,d\ne,ff\ng,h", PaddingOpts{Justification: JustifyLeft}, nil, "a ,b \n[cc,d ]\ne ,ff\n[g ,h ]\n"},
+	{"a,b\nnote\ncc,d\ne,ff", PaddingOpts{Justification: JustifyLeft, KeyValue: true}, nil, "a =b\nnote\n[cc=d]\ne =ff\n"},
+	{"a,b\ncc,d", PaddingOpts{Justification: JustifyLeft, FillWidth: 6}, nil, "a ,b  \n[cc,d  ]\n"},
+	{"a,b\ncc,d\ne,f", PaddingOpts{Justification: JustifyLeft}, []int{1}, "a ,b\n----\n[cc,d]\ne ,f\n"},
+}
 
-	b.ReportAllocs()
-	b.ResetTimer()
+// TestSetRowWrapper
+func TestSetRowWrapper(t *testing.T) {
+	for _, tt := range rowWrapperCases {
+		input, sep := tt.input, comma
+		if tt.po.KeyValue {
+			input, sep = strings.Replace(input, ",", "=", -1), "="
+		}
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader(input), out, sep, TextQualifier{})
+		a.UpdatePadding(tt.po)
+		a.SetRowWrapper(stripe)
+		a.RuleAfter(tt.rules)
+		a.Align()
 
-	for i := 0; i < b.N; i++ {
-		a.export()
+		if out.String() != tt.expected {
+			t.Fatalf("Align() of %q with SetRowWrapper = %q; want %q", input, out.String(), tt.expected)
+		}
 	}
 }
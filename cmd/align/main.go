@@ -13,7 +13,7 @@ import (
 	"github.com/Guitarbum722/align"
 )
 
-const usage = `Usage: align [-h] [-f] [-o] [-q] [-s] [-d] [-a] [-c] [-i] [-p]
+const usage = `Usage: align [-h] [-f] [-o] [-q] [-s] [-d] [-a] [-c] [-i] [-j] [-p]
 Options:
   -h | --help  help
   -f           input file.  If not specified, pipe input to stdin
@@ -24,6 +24,7 @@ Options:
   -a           <left>, <right>, <center> justification (default: left)
   -c           output specific fields (default: all fields)
   -i           override justification by column number (e.g. 2:center,5:right)
+  -j           justification of successive columns as l, r or c (e.g. lrc); the last applies to the rest
   -p           extra padding surrounding delimiter
   `
 
@@ -38,6 +39,7 @@ var (
 	aFlag    *string
 	cFlag    *string
 	iFlag    *string
+	jFlag    *string
 	pFlag    *int
 )
 
@@ -63,6 +65,7 @@ func init() {
 	aFlag = flag.String("a", "left", "")
 	cFlag = flag.String("c", "", "")
 	iFlag = flag.String("i", "", "")
+	jFlag = flag.String("j", "", "")
 	pFlag = flag.Int("p", 1, "")
 }
 
@@ -104,14 +107,7 @@ func run() (int, error) {
 					return 1, errors.New("make sure entry for -v are numbers with a justification separated by ':' (ie 1-right,3-center)")
 				}
 
-				switch overrides[1] {
-				case "left":
-					justifyOverrides[num] = align.JustifyLeft
-				case "center":
-					justifyOverrides[num] = align.JustifyCenter
-				case "right":
-					justifyOverrides[num] = align.JustifyRight
-				}
+				justifyOverrides[num], _ = align.ParseJustification(overrides[1])
 			}
 		}
 
@@ -181,30 +177,26 @@ func run() (int, error) {
 
 	aligner := align.NewAlign(input, output, *sFlag, qu)
 
-	switch *aFlag {
-	case "right":
-		aligner.UpdatePadding(align.PaddingOpts{
-			Justification:  align.JustifyRight,
-			ColumnOverride: justifyOverrides,
-			Pad:            *pFlag,
-		})
-	case "center":
-		aligner.UpdatePadding(align.PaddingOpts{
-			Justification:  align.JustifyCenter,
-			ColumnOverride: justifyOverrides,
-			Pad:            *pFlag,
-		})
-	default:
-		aligner.UpdatePadding(align.PaddingOpts{
-			Justification:  align.JustifyLeft,
-			ColumnOverride: justifyOverrides,
-			Pad:            *pFlag,
-		})
+	justification, err := align.ParseJustification(*aFlag)
+	if err != nil {
+		justification = align.JustifyLeft
+	}
+	aligner.UpdatePadding(align.PaddingOpts{
+		Justification:  justification,
+		ColumnOverride: justifyOverrides,
+		Pad:            *pFlag,
+	})
+	if *jFlag != "" {
+		if err := aligner.SetJustifications(*jFlag); err != nil {
+			return 1, errors.New("make sure entry for -j is a sequence of l, r or c (ie lrrc)")
+		}
 	}
 	aligner.FilterColumns(outColumns)
 	aligner.OutputSep(*dFlag)
 
-	aligner.Align()
+	if err := aligner.Align(); err != nil {
+		return 1, err
+	}
 
 	return 0, nil
 }
@@ -0,0 +1,69 @@
+//go:build go1.23
+
+package align
+
+import (
+	"fmt"
+	"io"
+	"iter"
+	"strings"
+)
+
+// Rows returns the fields of each line of the input, split in the same way as for alignment,
+// with the index (0-based) of the line among the lines that Align would write, which excludes
+// a Directive line and empty lines removed by EmptyLineSkip.  Lines outside of the LineRange
+// and lines that would be written unchanged are skipped.  No widths are determined and nothing
+// is written: the input is read as the sequence is iterated, so that the Align can be used as
+// a parser of large input.  The input can only be read once, so the Align cannot be aligned
+// afterwards.  Any error that ends the sequence, such as a line with more fields than
+// MaxColumns when MaxColumnsError is set, is returned by Err.
+func (a *Align) Rows() iter.Seq2[int, []string] {
+	return func(yield func(int, []string) bool) {
+		if a.done {
+			a.readErr = ErrAligned
+			return
+		}
+		a.start()
+		a.readErr = nil
+		if err := a.startCSV(); err != nil {
+			a.readErr = err
+			return
+		}
+
+		row := -1 // index of the line among the lines kept
+		for n := 1; ; n++ {
+			line, _, keep, err := a.nextLine(n)
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				a.readErr = err
+				return
+			}
+			if !keep {
+				continue
+			}
+			row++
+			if !a.inLineRange(row+1) || a.unalignedLine(line) {
+				continue
+			}
+
+			var words []string
+			if a.padOpts.CSV {
+				words = a.record
+			} else {
+				words = a.Split(line)
+			}
+			if m := a.maxColumns(); m > 0 && len(words) > m {
+				if a.padOpts.MaxColumnsError {
+					a.readErr = fmt.Errorf("align: line %d has more than %d fields", n, m)
+					return
+				}
+				words = append(words[:m-1], strings.Join(words[m-1:], a.sep))
+			}
+			if !yield(row, words) {
+				return
+			}
+		}
+	}
+}
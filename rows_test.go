@@ -0,0 +1,82 @@
+//go:build go1.23
+
+package align
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var rowsCases = []struct {
+	input     string
+	po        PaddingOpts
+	expected  [][]string
+	indexes   []int
+	lineRange []int
+}{
+	{"a,b\nccc,\"d,e\"\n", PaddingOpts{}, [][]string{{"a", "b"}, {"ccc", `"d,e"`}}, []int{0, 1}, nil},
+	{"a,b\n\nc,d", PaddingOpts{EmptyLines: EmptyLineSkip}, [][]string{{"a", "b"}, {"c", "d"}}, []int{0, 1}, nil},
+	{"\xef\xbb\xbfa,\"b\nc\"\nd,e", PaddingOpts{CSV: true}, [][]string{{"a", "b\nc"}, {"d", "e"}}, []int{0, 1}, nil},
+	{"k=v\nnote\nkk=vv", PaddingOpts{KeyValue: true}, [][]string{{"k", "v"}, {"kk", "vv"}}, []int{0, 2}, nil},
+	{"a,b\n\nc,d\ne,f", PaddingOpts{EmptyLines: EmptyLineSkip}, [][]string{{"c", "d"}, {"e", "f"}}, []int{1, 2}, []int{2, 0}},
+	{"#align: sep=|\na|b\nc,d|e", PaddingOpts{Directive: true}, [][]string{{"a", "b"}, {"c,d", "e"}}, []int{0, 1}, nil},
+	{"#align: sep=|\na|b\nc|d\ne|f", PaddingOpts{Directive: true}, [][]string{{"c", "d"}}, []int{1}, []int{2, 2}},
+	{"a,b,c\nd,e", PaddingOpts{MaxColumns: 2}, [][]string{{"a", "b,c"}, {"d", "e"}}, []int{0, 1}, nil},
+	{"a,b,c\nd,e", PaddingOpts{CSV: true, MaxColumns: 2}, [][]string{{"a", "b,c"}, {"d", "e"}}, []int{0, 1}, nil},
+}
+
+// TestRows
+func TestRows(t *testing.T) {
+	for _, tt := range rowsCases {
+		sep := comma
+		if tt.po.KeyValue {
+			sep = "="
+		}
+		out := &bytes.Buffer{}
+		a := NewAlign(strings.NewReader(tt.input), out, sep, TextQualifier{On: true, Qualifier: `"`})
+		a.UpdatePadding(tt.po)
+		if tt.lineRange != nil {
+			a.LineRange(tt.lineRange[0], tt.lineRange[1])
+		}
+
+		var rows [][]string
+		var indexes []int
+		for i, row := range a.Rows() {
+			rows = append(rows, row)
+			indexes = append(indexes, i)
+		}
+		if a.Err() != nil {
+			t.Fatalf("Rows() of %q ended with %v", tt.input, a.Err())
+		}
+		if !reflect.DeepEqual(rows, tt.expected) || !reflect.DeepEqual(indexes, tt.indexes) {
+			t.Fatalf("Rows() of %q = %q at %v; want %q at %v", tt.input, rows, indexes, tt.expected, tt.indexes)
+		}
+		if out.Len() != 0 {
+			t.Fatalf("Rows() of %q wrote %q", tt.input, out.String())
+		}
+	}
+
+	a := NewAlign(strings.NewReader("a,b\nc,d\ne,f"), &bytes.Buffer{}, comma, TextQualifier{})
+	for i := range a.Rows() {
+		if i == 1 {
+			break
+		}
+	}
+	if err := a.Align(); err != ErrAligned {
+		t.Fatalf("Align() after Rows() = %v; want %v", err, ErrAligned)
+	}
+
+	for _, po := range []PaddingOpts{{MaxColumns: 2, MaxColumnsError: true}, {CSV: true, MaxColumns: 2, MaxColumnsError: true}} {
+		a = NewAlign(strings.NewReader("a,b\nc,d,e\nf,g"), &bytes.Buffer{}, comma, TextQualifier{})
+		a.UpdatePadding(po)
+		var rows [][]string
+		for _, row := range a.Rows() {
+			rows = append(rows, row)
+		}
+		if !reflect.DeepEqual(rows, [][]string{{"a", "b"}}) || a.Err() == nil {
+			t.Fatalf("Rows() with %+v = %q, %v; want %q and an error", po, rows, a.Err(), [][]string{{"a", "b"}})
+		}
+	}
+}